@@ -0,0 +1,55 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/util"
+)
+
+// `seneca gc` removes workspaces abandoned by crashed runs
+func runGc(arguments []string) int {
+	args := util.NewGcArguments()
+	if err := args.Parse(arguments); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+
+	if err := args.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+	args.ConfigureLog(args.Verbose)
+
+	swept, err := io.Sweep(args.WorkDir, args.OlderThan, args.DryRun)
+	for _, dir := range swept {
+		if args.DryRun {
+			fmt.Printf("  would remove %s\n", dir)
+		} else {
+			fmt.Printf("  removed %s\n", dir)
+		}
+	}
+	if err != nil {
+		util.Log.Error("sweeping workspaces failed", "error", err)
+		return 1
+	}
+	return 0
+}
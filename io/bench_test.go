@@ -0,0 +1,122 @@
+package io
+
+import (
+	"fmt"
+	"github.com/javouhey/seneca/util"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// Compares the x264 intermediate with -direct on a video that
+// ffmpeg's lavfi synthesizes. Needs ffmpeg & ffprobe:
+//
+//	go test -run NONE -bench Gif ./io
+//
+// Besides the time per GIF, it reports the size of the GIF &
+// its PSNR against the source in dB, higher being closer.
+func BenchmarkGifX264(b *testing.B) {
+	benchmarkGif(b)
+}
+
+func BenchmarkGifDirect(b *testing.B) {
+	benchmarkGif(b, "-direct")
+}
+
+const BENCH_SOURCE = "testsrc2=duration=4:size=480x270:rate=30"
+
+var rgxPsnr = regexp.MustCompile(`average:(\d+\.\d+|inf)`)
+
+func benchmarkGif(b *testing.B, options ...string) {
+	SetRunner(nil)
+	if err := Configure(Programs{}); err != nil {
+		b.Skip("needs ffmpeg & ffprobe: ", err)
+	}
+	tmp, err := ioutil.TempDir("", "seneca-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	source := filepath.Join(tmp, "testsrc.mp4")
+	err = execute([]string{ffmpegExec, "-f", "lavfi", "-i", BENCH_SOURCE,
+		"-pix_fmt", "yuv420p", "-y", source}, nil, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// ffmpeg gives up when nobody takes its -progress
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	var gif string
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		args := util.NewArguments()
+		err := args.Parse(append([]string{"-port", port, "-length", "4s",
+			"-fps", "15"}, options...))
+		if err != nil {
+			b.Fatal(err)
+		}
+		vr, err := NewVideoReader(source, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		vr.Root = tmp
+		p := new(Pipeline)
+		p.Run(vr, args)
+		if err := p.Tombstone.Wait(); err != nil {
+			b.Fatal(err)
+		}
+		vr.Cleanup(false)
+		gif = vr.Result()
+	}
+	b.StopTimer()
+
+	fi, err := os.Stat(gif)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(fi.Size()), "bytes")
+	if psnr, err := benchPsnr(gif, source); err == nil {
+		b.ReportMetric(psnr, "dB")
+	} else {
+		b.Log("no PSNR: ", err)
+	}
+}
+
+// PSNR of the GIF against the source sampled at the same rate
+func benchPsnr(gif, source string) (float64, error) {
+	tail := NewTail(TAIL_SIZE)
+	proc, err := currentRunner().Start([]string{ffmpegExec, "-i", gif,
+		"-i", source, "-lavfi",
+		"[0:v]format=yuv420p[a];[1:v]fps=15,format=yuv420p[b];[a][b]psnr",
+		"-f", "null", "-"}, tail)
+	if err != nil {
+		return 0, err
+	}
+	if err = proc.Wait(); err != nil {
+		return 0, err
+	}
+	m := rgxPsnr.FindStringSubmatch(tail.String())
+	if m == nil {
+		return 0, fmt.Errorf("psnr missing from %q", tail.String())
+	}
+	if m[1] == "inf" {
+		return 100, nil
+	}
+	return strconv.ParseFloat(m[1], 64)
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	stdio "io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/javouhey/seneca/util"
+)
+
+const (
+	CONCAT = "frames.txt"
+
+	// Fingerprints are FPSIDE x FPSIDE grids of luma
+	FPSIDE = 32
+)
+
+// A downsampled grayscale rendition of a frame. The cells keep
+// 16 bits so that a few changed pixels, e.g. a typed character
+// in a screen capture, still tell two frames apart.
+type Fingerprint [FPSIDE * FPSIDE]uint16
+
+// Mean absolute difference as a percentage of full scale
+func (f *Fingerprint) Distance(other *Fingerprint) float64 {
+	var total int
+	for i := range f {
+		d := int(f[i]) - int(other[i])
+		if d < 0 {
+			d = -d
+		}
+		total += d
+	}
+	return float64(total) * 100.0 / float64(len(f)*0xffff)
+}
+
+func NewFingerprint(img image.Image) *Fingerprint {
+	var (
+		fp     Fingerprint
+		sums   [FPSIDE * FPSIDE]uint64
+		counts [FPSIDE * FPSIDE]uint64
+	)
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return &fp
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := (y - b.Min.Y) * FPSIDE / h
+		for x := b.Min.X; x < b.Max.X; x++ {
+			col := (x - b.Min.X) * FPSIDE / w
+			r, g, bl, _ := img.At(x, y).RGBA()
+			// ITU-R 601 luma on 16 bit channels
+			luma := (299*uint64(r) + 587*uint64(g) + 114*uint64(bl)) / 1000
+			sums[row*FPSIDE+col] += luma
+			counts[row*FPSIDE+col]++
+		}
+	}
+	for i := range fp {
+		if counts[i] > 0 {
+			fp[i] = uint16(sums[i] / counts[i])
+		}
+	}
+	return &fp
+}
+
+// A frame that is shown for Repeat frame intervals
+type Run struct {
+	File   string
+	Repeat int
+}
+
+// Collapses consecutive frames whose distance from the first
+// frame of their run is within tolerance (in percent).
+func collapse(files []string, fps []*Fingerprint, tolerance float64) []Run {
+	runs := make([]Run, 0)
+	var head *Fingerprint
+	for i, file := range files {
+		if head != nil && head.Distance(fps[i]) <= tolerance {
+			runs[len(runs)-1].Repeat++
+			continue
+		}
+		head = fps[i]
+		runs = append(runs, Run{File: file, Repeat: 1})
+	}
+	return runs
+}
+
+// Writes an ffconcat script in which every run keeps its
+// original screen time. The last file is repeated because the
+// concat demuxer ignores the duration of the final entry.
+func writeConcat(w stdio.Writer, runs []Run, fps int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "ffconcat version 1.0")
+	for _, r := range runs {
+		fmt.Fprintf(bw, "file '%s'\n", filepath.ToSlash(r.File))
+		fmt.Fprintf(bw, "duration %g\n", float64(r.Repeat)/float64(fps))
+	}
+	if len(runs) > 0 {
+		fmt.Fprintf(bw, "file '%s'\n", filepath.ToSlash(runs[len(runs)-1].File))
+	}
+	return bw.Flush()
+}
+
+func fingerprintFile(file string) (*Fingerprint, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	img, err := png.Decode(fh)
+	if err != nil {
+		return nil, err
+	}
+	return NewFingerprint(img), nil
+}
+
+// Removes duplicate frames from vr.PngDir & describes the
+// survivors with their durations in vr.Concat
+type Deduplicator struct{}
+
+// a priori: FrameGenerator task was executed without errors
+func (d Deduplicator) Run(vr *VideoReader, args *util.Arguments) <-chan error {
+	reply := make(chan error)
+	vr.Concat = CONCAT
+	go func() {
+		if args.DryRun {
+			vr.printf("  dedup %s within %g%% into %s\n",
+				filepath.Join(vr.PngDir, "*.png"), args.DedupTolerance,
+				filepath.Join(vr.TmpDir, vr.Concat))
+			reply <- nil
+			return
+		}
+
+		files, err := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+		if err != nil {
+			reply <- err
+			return
+		}
+		sort.Strings(files)
+
+		fps := make([]*Fingerprint, len(files))
+		for i, file := range files {
+			if fps[i], err = fingerprintFile(file); err != nil {
+				util.Log.Error("unable to read frame", "file", file, "error", err)
+				reply <- err
+				return
+			}
+		}
+
+		runs := collapse(files, fps, args.DedupTolerance)
+		if err := d.prune(files, runs); err != nil {
+			reply <- err
+			return
+		}
+		util.Log.Debug("dedup", "kept", len(runs), "frames", len(files))
+
+		fh, err := os.Create(filepath.Join(vr.TmpDir, vr.Concat))
+		if err != nil {
+			reply <- err
+			return
+		}
+		err = writeConcat(fh, runs, args.Fps)
+		if cerr := fh.Close(); err == nil {
+			err = cerr
+		}
+		reply <- err
+	}()
+	return reply
+}
+
+func (d Deduplicator) prune(files []string, runs []Run) error {
+	keep := make(map[string]struct{}, len(runs))
+	for _, r := range runs {
+		keep[r.File] = struct{}{}
+	}
+	for _, file := range files {
+		if _, ok := keep[file]; ok {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
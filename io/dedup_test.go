@@ -0,0 +1,120 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/javouhey/seneca/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func solid(c uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = c
+	}
+	return img
+}
+
+func TestFingerprint(t *testing.T) {
+	black := NewFingerprint(solid(0))
+	white := NewFingerprint(solid(255))
+	grey := NewFingerprint(solid(2))
+
+	assert.Equal(t, 0.0, black.Distance(black))
+	assert.Equal(t, 100.0, black.Distance(white))
+	assert.InDelta(t, 0.78, black.Distance(grey), 0.01)
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	img.Set(0, 0, color.RGBA{255, 255, 255, 255})
+	assert.True(t, NewFingerprint(img).Distance(black) > 0.0)
+}
+
+func TestFingerprintSmallChange(t *testing.T) {
+	screen := func(cursor bool) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 1920, 1080))
+		for i := range img.Pix {
+			img.Pix[i] = 240
+		}
+		if cursor {
+			// a 2x16 text cursor, 0.0015% of the screen
+			for y := 500; y < 516; y++ {
+				img.SetGray(960, y, color.Gray{0})
+				img.SetGray(961, y, color.Gray{0})
+			}
+		}
+		return img
+	}
+	off, on := NewFingerprint(screen(false)), NewFingerprint(screen(true))
+	assert.True(t, off.Distance(on) > 0.0)
+
+	tolerance := util.NewArguments().DedupTolerance
+	runs := collapse([]string{"1.png", "2.png", "3.png"},
+		[]*Fingerprint{off, on, off}, tolerance)
+	assert.Equal(t, []Run{{"1.png", 1}, {"2.png", 1}, {"3.png", 1}}, runs)
+}
+
+func TestCollapse(t *testing.T) {
+	files := []string{"1.png", "2.png", "3.png", "4.png", "5.png"}
+	fps := []*Fingerprint{
+		NewFingerprint(solid(0)),
+		NewFingerprint(solid(1)),
+		NewFingerprint(solid(2)),
+		NewFingerprint(solid(200)),
+		NewFingerprint(solid(0)),
+	}
+
+	runs := collapse(files, fps, 0.5)
+	assert.Equal(t, []Run{{"1.png", 2}, {"3.png", 1}, {"4.png", 1},
+		{"5.png", 1}}, runs)
+
+	runs = collapse(files, fps, 1.0)
+	assert.Equal(t, []Run{{"1.png", 3}, {"4.png", 1}, {"5.png", 1}}, runs)
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeConcat(&buf, runs, 10))
+	assert.Equal(t, "ffconcat version 1.0\n"+
+		"file '1.png'\nduration 0.3\n"+
+		"file '4.png'\nduration 0.1\n"+
+		"file '5.png'\nduration 0.1\n"+
+		"file '5.png'\n", buf.String())
+}
+
+func TestDeduplicator(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "seneca-dedup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	vr := &VideoReader{Work: Work{TmpDir: tmp, PngDir: filepath.Join(tmp, PDIR)}}
+	assert.NoError(t, os.MkdirAll(vr.PngDir, os.ModePerm))
+	for i, c := range []uint8{10, 10, 10, 90, 90} {
+		fh, err := os.Create(filepath.Join(vr.PngDir,
+			fmt.Sprintf("img-%03d.png", i+1)))
+		assert.NoError(t, err)
+		assert.NoError(t, png.Encode(fh, solid(c)))
+		fh.Close()
+	}
+
+	a := util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-dedup", "-fps", "5"}))
+	assert.NoError(t, <-new(Deduplicator).Run(vr, a))
+
+	left, _ := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	assert.Equal(t, 2, len(left))
+
+	script, err := ioutil.ReadFile(filepath.Join(tmp, CONCAT))
+	assert.NoError(t, err)
+	assert.Contains(t, string(script), "img-001.png'\nduration 0.6\n")
+	assert.Contains(t, string(script), "img-004.png'\nduration 0.4\n")
+
+	cmd := new(Muxer).prepCli(vr, a)
+	assert.Contains(t, cmd, filepath.Join(tmp, CONCAT))
+	assert.Contains(t, cmd, "vfr")
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// How much of a program's stderr is kept for its ExecError
+const TAIL_SIZE = 16 << 10
+
+// Tail is a ring buffer that keeps the last bytes written to it
+type Tail struct {
+	mu   sync.Mutex
+	buf  []byte
+	pos  int
+	full bool
+}
+
+func NewTail(size int) *Tail {
+	return &Tail{buf: make([]byte, size)}
+}
+
+func (t *Tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(p)
+	if n > len(t.buf) {
+		p = p[n-len(t.buf):]
+	}
+	for len(p) > 0 {
+		c := copy(t.buf[t.pos:], p)
+		p = p[c:]
+		if t.pos += c; t.pos == len(t.buf) {
+			t.pos, t.full = 0, true
+		}
+	}
+	return n, nil
+}
+
+// The kept bytes, starting at a whole line once some were dropped
+func (t *Tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.full {
+		return string(t.buf[:t.pos])
+	}
+	s := string(t.buf[t.pos:]) + string(t.buf[:t.pos])
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+// A failed run of ffmpeg or ffprobe. The failures that are
+// recognized in its stderr are reported as one of the error
+// types below, which embed it.
+type ExecError struct {
+	Cmd    []string
+	Stderr string // at most the last TAIL_SIZE bytes
+	Line   string // the stderr line that gives the cause
+	Err    error  // as returned by Wait
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("%s: %v", e.program(), e.Err)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ExecError) program() string {
+	return filepath.Base(e.Cmd[0])
+}
+
+func (e *ExecError) explain(cause string) string {
+	return fmt.Sprintf("%s: %s (%s)", e.program(), cause, e.Line)
+}
+
+type UnknownEncoderError struct{ *ExecError }
+
+func (e *UnknownEncoderError) Error() string {
+	return e.explain("built without an encoder that seneca needs")
+}
+
+type FilterGraphError struct{ *ExecError }
+
+func (e *FilterGraphError) Error() string {
+	return e.explain("the filter graph is invalid, check -scale & -speed")
+}
+
+type NoSuchFileError struct{ *ExecError }
+
+func (e *NoSuchFileError) Error() string {
+	return e.explain("a file does not exist")
+}
+
+type PermissionError struct{ *ExecError }
+
+func (e *PermissionError) Error() string {
+	return e.explain("a file or directory is not accessible")
+}
+
+type DiskFullError struct{ *ExecError }
+
+func (e *DiskFullError) Error() string {
+	return e.explain("the disk is full, see -workdir")
+}
+
+type InvalidSeekError struct{ *ExecError }
+
+func (e *InvalidSeekError) Error() string {
+	return e.explain("nothing at -from, is it past the end of the video?")
+}
+
+// In order, the first match wins
+var failures = []struct {
+	rgx  *regexp.Regexp
+	wrap func(*ExecError) error
+}{
+	{regexp.MustCompile(`No space left on device`),
+		func(e *ExecError) error { return &DiskFullError{e} }},
+	{regexp.MustCompile(`Permission denied`),
+		func(e *ExecError) error { return &PermissionError{e} }},
+	{regexp.MustCompile(`Unknown encoder|Encoder \S+ not found`),
+		func(e *ExecError) error { return &UnknownEncoderError{e} }},
+	{regexp.MustCompile(`No such filter|Error (re)?initializing filter|` +
+		`Error parsing (a )?filter|Failed to configure (in|out)put pad`),
+		func(e *ExecError) error { return &FilterGraphError{e} }},
+	{regexp.MustCompile(`could not seek to position|` +
+		`Output file is empty, nothing was encoded`),
+		func(e *ExecError) error { return &InvalidSeekError{e} }},
+	{regexp.MustCompile(`No such file or directory`),
+		func(e *ExecError) error { return &NoSuchFileError{e} }},
+}
+
+// The Tail of output already collected in full
+func tailOf(output []byte) string {
+	t := NewTail(TAIL_SIZE)
+	t.Write(output)
+	return t.String()
+}
+
+// Wraps the err of a failed cmdFull into an ExecError, or into
+// one of the types embedding it when stderr gives the cause.
+func classify(cmdFull []string, stderr string, err error) error {
+	e := &ExecError{Cmd: cmdFull, Stderr: stderr, Err: err}
+	lines := strings.Split(stderr, "\n")
+	for _, f := range failures {
+		for _, line := range lines {
+			if f.rgx.MatchString(line) {
+				e.Line = strings.TrimSpace(line)
+				return f.wrap(e)
+			}
+		}
+	}
+	return e
+}
@@ -0,0 +1,51 @@
+package io
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestTail(t *testing.T) {
+	tail := NewTail(20)
+	tail.Write([]byte("frame=1\n"))
+	assert.Equal(t, "frame=1\n", tail.String())
+
+	tail.Write([]byte("frame=2\nframe=3\n"))
+	assert.Equal(t, "frame=2\nframe=3\n", tail.String())
+
+	// the partial first line is dropped
+	tail.Write([]byte("Error\n"))
+	assert.Equal(t, "frame=3\nError\n", tail.String())
+
+	n, _ := tail.Write([]byte(strings.Repeat("x", 40) + "\nlast\n"))
+	assert.Equal(t, 46, n)
+	assert.Equal(t, "last\n", tail.String())
+}
+
+func TestClassify(t *testing.T) {
+	cmd := []string{"/usr/bin/ffmpeg", "-i", "in.mp4"}
+	exit := errors.New("exit status 1")
+	for stderr, want := range map[string]interface{}{
+		"Unknown encoder 'libx264'":                             &UnknownEncoderError{},
+		"[AVFilterGraph @ 0x1] No such filter: 'sacle'":         &FilterGraphError{},
+		"Error initializing filter 'scale' with args 'x'":       &FilterGraphError{},
+		"in.mp4: No such file or directory":                     &NoSuchFileError{},
+		"/tmp/seneca/p/img-001.png: Permission denied":          &PermissionError{},
+		"av_interleaved_write_frame(): No space left on device": &DiskFullError{},
+		"in.mp4: could not seek to position 3600.000":           &InvalidSeekError{},
+		"Output file is empty, nothing was encoded":             &InvalidSeekError{},
+		"Conversion failed!":                                    &ExecError{},
+	} {
+		err := classify(cmd, "ffmpeg version 2.2\n"+stderr+"\n", exit)
+		assert.IsType(t, want, err, stderr)
+		assert.Equal(t, exit, errors.Unwrap(err), stderr)
+	}
+
+	err := classify(cmd, "in.mp4: No such file or directory\n", exit)
+	assert.Equal(t, "ffmpeg: a file does not exist "+
+		"(in.mp4: No such file or directory)", err.Error())
+	assert.Equal(t, "ffmpeg: exit status 1",
+		classify(cmd, "", exit).Error())
+}
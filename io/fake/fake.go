@@ -0,0 +1,323 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+// A scriptable stand-in for ffmpeg & ffprobe, for tests
+//
+//	r := fake.NewRunner()
+//	r.On("ffprobe").Stderr = fake.Probe(60*time.Second, 640, 480, 25)
+//	r.On("libx264").Fail = 1
+//	io.SetRunner(r)
+//	defer io.SetRunner(nil)
+package fake
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	stdio "io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/javouhey/seneca/io"
+)
+
+// What a matching invocation does, in this order: wait for
+// Delay, write Stderr, post Progress, drain stdin, write frames
+// to stdout, create outputs & exit with Fail.
+type Rule struct {
+	Match    string        // substring of the space joined argv
+	Delay    time.Duration // Terminate or Kill cuts it short
+	Stderr   string
+	Progress []string // bodies posted to the -progress url
+	Frames   int      // images for img-%03d.png, see -start_number & -frames:v, or of a .gif
+	Fail     int      // exit status
+
+	// Terminate has no effect, only Kill cuts Delay short
+	IgnoreTerm bool
+}
+
+// Reported by Wait for a non zero Rule.Fail
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Records every invocation & answers with the last Rule whose
+// Match is found in the command line. Without a match it
+// succeeds silently after creating the output file.
+type Runner struct {
+	mu    sync.Mutex
+	rules []*Rule
+	calls [][]string
+}
+
+func NewRunner() *Runner {
+	return new(Runner)
+}
+
+// On adds a Rule for command lines that contain match
+func (r *Runner) On(match string) *Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rule := &Rule{Match: match, Frames: 3}
+	r.rules = append(r.rules, rule)
+	return rule
+}
+
+// Calls returns the argv of every Start so far
+func (r *Runner) Calls() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := make([][]string, len(r.calls))
+	copy(calls, r.calls)
+	return calls
+}
+
+// Every program is found where it is asked for
+func (r *Runner) LookPath(file string) (string, error) {
+	return file, nil
+}
+
+func (r *Runner) match(argv []string) Rule {
+	line := strings.Join(argv, " ")
+	for i := len(r.rules) - 1; i >= 0; i-- {
+		if strings.Contains(line, r.rules[i].Match) {
+			return *r.rules[i]
+		}
+	}
+	return Rule{Frames: 3}
+}
+
+func (r *Runner) Start(argv []string, stderr stdio.Writer) (io.Process, error) {
+	return r.StartPiped(argv, nil, nil, stderr)
+}
+
+// stdin is read to its end & stdout gets the Frames
+func (r *Runner) StartPiped(argv []string, stdin stdio.Reader,
+	stdout, stderr stdio.Writer) (io.Process, error) {
+
+	r.mu.Lock()
+	r.calls = append(r.calls, append([]string(nil), argv...))
+	rule := r.match(argv)
+	r.mu.Unlock()
+
+	p := &process{
+		argv:   argv,
+		rule:   rule,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		termed: make(chan struct{}),
+		killed: make(chan struct{}),
+		done:   make(chan error, 1),
+	}
+	go p.run()
+	return p, nil
+}
+
+type process struct {
+	argv   []string
+	rule   Rule
+	stdin  stdio.Reader
+	stdout stdio.Writer
+	stderr stdio.Writer
+	term   sync.Once
+	kill   sync.Once
+	termed chan struct{}
+	killed chan struct{}
+	done   chan error
+}
+
+func (p *process) Wait() error {
+	return <-p.done
+}
+
+func (p *process) Terminate() error {
+	if !p.rule.IgnoreTerm {
+		p.term.Do(func() { close(p.termed) })
+	}
+	return nil
+}
+
+func (p *process) Kill() error {
+	p.kill.Do(func() { close(p.killed) })
+	return nil
+}
+
+func (p *process) run() {
+	select {
+	case <-time.After(p.rule.Delay):
+	case <-p.termed:
+		p.done <- &ExitError{Code: 255}
+		return
+	case <-p.killed:
+		p.done <- &ExitError{Code: -1}
+		return
+	}
+
+	if p.stderr != nil && p.rule.Stderr != "" {
+		stdio.WriteString(p.stderr, p.rule.Stderr)
+	}
+	if url := p.flag("-progress"); url != "" {
+		bodies := p.rule.Progress
+		if bodies == nil {
+			bodies = []string{"frame=1\nprogress=continue\n",
+				fmt.Sprintf("frame=%d\nprogress=end\n", p.rule.Frames)}
+		}
+		for _, body := range bodies {
+			post(url, body)
+		}
+	}
+	if err := p.pipe(); err != nil {
+		p.done <- err
+		return
+	}
+	if p.rule.Fail != 0 {
+		p.done <- &ExitError{Code: p.rule.Fail}
+		return
+	}
+	p.done <- p.create()
+}
+
+// Drains stdin & writes the frames to stdout, like ffmpeg with
+// -i pipe:0 & image2pipe. A broken pipe is a failure.
+func (p *process) pipe() error {
+	piped := make(chan error, 1)
+	go func() {
+		if p.stdin != nil {
+			if _, err := stdio.Copy(ioutil.Discard, p.stdin); err != nil {
+				piped <- &ExitError{Code: 1}
+				return
+			}
+		}
+		if p.stdout != nil {
+			for i := 1; i <= p.rule.Frames; i++ {
+				if _, err := p.stdout.Write(Frame(i)); err != nil {
+					piped <- &ExitError{Code: 1}
+					return
+				}
+			}
+		}
+		piped <- nil
+	}()
+	select {
+	case err := <-piped:
+		return err
+	case <-p.termed:
+		return &ExitError{Code: 255}
+	case <-p.killed:
+		return &ExitError{Code: -1}
+	}
+}
+
+func (p *process) flag(name string) string {
+	for i, arg := range p.argv {
+		if arg == name && i+1 < len(p.argv) {
+			return p.argv[i+1]
+		}
+	}
+	return ""
+}
+
+// ffmpeg writes to its last argument
+func (p *process) create() error {
+	if len(p.argv) < 2 || strings.Contains(p.argv[0], "ffprobe") {
+		return nil
+	}
+	out := p.argv[len(p.argv)-1]
+	if out == "-" || out == "-h" || strings.HasPrefix(out, "pipe:") {
+		return nil
+	}
+	if strings.HasSuffix(out, ".gif") {
+		return ioutil.WriteFile(out, Gif(p.rule.Frames), 0644)
+	}
+	if !strings.Contains(out, "%") {
+		return ioutil.WriteFile(out, []byte(strings.Join(p.argv, " ")), 0644)
+	}
+	first, count := 1, p.rule.Frames
+	if n, err := strconv.Atoi(p.flag("-start_number")); err == nil {
+		first = n
+	}
+	if n, err := strconv.Atoi(p.flag("-frames:v")); err == nil && n < count {
+		count = n
+	}
+	for i := first; i < first+count; i++ {
+		if err := ioutil.WriteFile(fmt.Sprintf(out, i), Frame(i), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func post(url, body string) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", "Lavf/fake")
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// Frame returns a small PNG whose shade is derived from n
+func Frame(n int) []byte {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(n * 40)
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// Gif returns a small animated GIF of n frames shaded like Frame
+func Gif(n int) []byte {
+	anim := new(gif.GIF)
+	for i := 1; i <= n; i++ {
+		img := image.NewPaletted(image.Rect(0, 0, 64, 48), palette.Plan9)
+		for j := range img.Pix {
+			img.Pix[j] = uint8(img.Palette.Index(color.Gray{uint8(i * 40)}))
+		}
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	gif.EncodeAll(&buf, anim)
+	return buf.Bytes()
+}
+
+// Probe mimics what ffprobe prints for a video
+func Probe(duration time.Duration, width, height int, fps float64) string {
+	secs := int64(duration.Seconds())
+	return fmt.Sprintf("Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'video.mp4':\n"+
+		"  Duration: %02d:%02d:%02d.00, start: 0.000000, bitrate: 709 kb/s\n"+
+		"    Stream #0:0(und): Video: h264 (High) (avc1 / 0x31637661), "+
+		"yuv420p, %dx%d [SAR 1:1 DAR 4:3], 2020 kb/s, %g fps, %g tbr, 30k tbn,\n",
+		secs/3600, secs/60%60, secs%60, width, height, fps, fps)
+}
@@ -20,17 +20,17 @@ package io
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	stdio "io"
+	"math"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
-	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/javouhey/seneca/util"
@@ -43,30 +43,77 @@ var (
 )
 
 const (
-	CHUNK  = 1024
 	APPDIR = "seneca"
 	PDIR   = "p"
 	TMPMP4 = "temp.mp4"
+	// Work.Log of the CLI & watch, kept when a run fails
+	LOGFILE = "ffmpeg.log"
 
 	INVALID_VIDEO = "File %q not a recognizable video file\n\n%s\n"
 	MISSING_PROG  = "Missing executable %q on your $PATH.\n\n%s\n"
+
+	ENV_FFMPEG  = "SENECA_FFMPEG"
+	ENV_FFPROBE = "SENECA_FFPROBE"
 )
 
-func assignProgram(prog string, exec *string) {
-	flag, err := util.IsExistProgram(prog)
+// Locations of the ffmpeg tools. Empty fields fall back to the
+// environment variables ENV_FFMPEG & ENV_FFPROBE, then to $PATH.
+type Programs struct {
+	Ffmpeg  string
+	Ffprobe string
+}
+
+// Reported when a program cannot be resolved or executed
+type ProgramError struct {
+	Program string
+	Err     error
+}
+
+func (e *ProgramError) Error() string {
+	return fmt.Sprintf("Missing executable %q: %v", e.Program, e.Err)
+}
+
+var ErrNotConfigured = errors.New("ffmpeg tools not configured; call io.Configure")
+
+func resolveProgram(path, env, prog string) (string, error) {
+	candidate := path
+	if util.IsEmpty(candidate) {
+		candidate = os.Getenv(env)
+	}
+	if util.IsEmpty(candidate) {
+		candidate = prog
+	}
+
+	r := currentRunner()
+	resolved, err := r.LookPath(candidate)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, MISSING_PROG, prog, util.ShortHelp)
-		syscall.Exit(127)
+		return "", &ProgramError{prog, err}
+	}
+
+	// as util.IsExistProgram: it must run
+	proc, err := r.Start([]string{resolved, "-h"}, nil)
+	if err == nil {
+		err = proc.Wait()
 	}
-	if flag {
-		p := reflect.ValueOf(exec)
-		p.Elem().SetString(prog)
+	if err != nil {
+		return "", &ProgramError{prog, err}
 	}
+	return resolved, nil
 }
 
-func init() {
-	assignProgram("ffprobe", &ffprobeExec)
-	assignProgram("ffmpeg", &ffmpegExec)
+// Configure locates ffprobe & ffmpeg. It must succeed before any
+// video is read.
+func Configure(p Programs) error {
+	probe, err := resolveProgram(p.Ffprobe, ENV_FFPROBE, "ffprobe")
+	if err != nil {
+		return err
+	}
+	mpeg, err := resolveProgram(p.Ffmpeg, ENV_FFMPEG, "ffmpeg")
+	if err != nil {
+		return err
+	}
+	ffprobeExec, ffmpegExec = probe, mpeg
+	return nil
 }
 
 type VideoSize struct {
@@ -75,18 +122,58 @@ type VideoSize struct {
 
 // Dynamic values depending on time, inputs & OS
 type Work struct {
+	Root    string // os.TempDir() when empty
 	TmpDir  string
 	PngDir  string
 	TmpFile string
 	Gif     string
+	Sheet   string
+	Concat  string
+	Log     string // collects ffmpeg's stderr when set
+
+	// TmpDir when set, otherwise TmpDir is only a name
+	Workspace *Workspace
 }
 
 type VideoReader struct {
 	Filename string
 	Fps      float32
 	Duration time.Duration
+
+	// ffprobe printed Duration: N/A e.g. for streams & some
+	// growing files. Duration is then meaningless.
+	UnknownDuration bool
+	Start           time.Duration // timestamp of the first frame
+
+	Rate util.Rational // exact Fps, zero when unknown
+
+	// Clockwise degrees the video is displayed turned by, from
+	// its rotate tag or display matrix. VideoSize & the aspect
+	// ratios are as stored, see Oriented.
+	Rotation int
 	VideoSize
+
+	// [SAR 64:45 DAR 16:9] of anamorphic videos, the zero
+	// Rational when ffprobe did not say
+	SampleAspect  util.Rational
+	DisplayAspect util.Rational
 	Work
+
+	Loop *LoopPoint // picked by -auto-loop
+
+	// Where -dry-run prints what it would run, stdout when nil
+	Out stdio.Writer
+}
+
+func (v *VideoReader) printf(format string, a ...interface{}) {
+	fmt.Fprintf(stdout(v.Out), format, a...)
+}
+
+func stdout(w stdio.Writer) stdio.Writer {
+	if w == nil {
+		return os.Stdout
+	}
+	return w
 }
 
 func (w Work) String() string {
@@ -94,9 +181,89 @@ func (w Work) String() string {
 	cmdFull = append(cmdFull, "  Workdir: ", w.TmpDir)
 	cmdFull = append(cmdFull, "\n   Frames: ", w.TmpFile)
 	cmdFull = append(cmdFull, "\n      Gif: ", w.Gif, "\n")
+	if !util.IsEmpty(w.Sheet) {
+		cmdFull = append(cmdFull, "    Sheet: ", w.Sheet, "\n")
+	}
 	return strings.Join(cmdFull, "")
 }
 
+func (w Work) log() {
+	util.Log.Debug("work", "dir", w.TmpDir, "frames", w.TmpFile,
+		"gif", w.Gif, "sheet", w.Sheet)
+}
+
+// Full path of Log, or empty when ffmpeg's stderr is discarded
+func (w Work) LogFile() string {
+	if util.IsEmpty(w.Log) || util.IsEmpty(w.TmpDir) {
+		return ""
+	}
+	return filepath.Join(w.TmpDir, w.Log)
+}
+
+// Full path of the file produced by the pipeline
+func (w Work) Result() string {
+	if !util.IsEmpty(w.Sheet) {
+		return filepath.Join(w.TmpDir, w.Sheet)
+	}
+	return filepath.Join(w.TmpDir, w.Gif)
+}
+
+// Remaining returns how much of the video follows offset. It
+// is false when the duration is unknown.
+func (v *VideoReader) Remaining(offset time.Duration) (time.Duration, bool) {
+	if v.UnknownDuration {
+		return 0, false
+	}
+	if offset >= v.Duration {
+		return 0, true
+	}
+	return v.Duration - offset, true
+}
+
+// CheckWindow rejects a -from at or past the end of the video
+// & an Overscan past its end. Nothing can be checked when the
+// duration is unknown.
+func (v *VideoReader) CheckWindow(args *util.Arguments) error {
+	if args.FromNow {
+		return nil
+	}
+	left, known := v.Remaining(args.From.Offset())
+	if known && left == 0 {
+		return fmt.Errorf("-from %s is not before the end of the video (%s)",
+			args.From, util.NewTimeCode(v.Duration))
+	}
+	// the stages after FrameGenerator expect every frame of it
+	if over := args.Overscan(); known && over > 0 && args.Length+over > left {
+		return fmt.Errorf("-length %s & the %s past it for -smooth-loop "+
+			"or -auto-loop run beyond the end of the video (%s)",
+			args.Length, over, util.NewTimeCode(v.Duration))
+	}
+	return nil
+}
+
+// ResolveWindow turns frame numbers into -from & -length,
+// then checks the window.
+func (v *VideoReader) ResolveWindow(args *util.Arguments) error {
+	if err := args.ResolveFrames(v.Rate); err != nil {
+		return err
+	}
+	return v.CheckWindow(args)
+}
+
+// Input options that position ffmpeg at -from. With -from now a
+// growing file is followed from its current end, or from where
+// it is when that is unknown.
+func (v *VideoReader) seek(args *util.Arguments) []string {
+	if !args.FromNow {
+		return []string{"-ss", args.From.String()}
+	}
+	opts := []string{"-follow", "1"}
+	if !v.UnknownDuration {
+		opts = append(opts, "-ss", util.NewTimeCode(v.Duration).String())
+	}
+	return opts
+}
+
 func (vs VideoSize) String() string {
 	return fmt.Sprintf("%dx%d", vs.Width, vs.Height)
 }
@@ -104,20 +271,37 @@ func (vs VideoSize) String() string {
 func (vr VideoReader) String() string {
 	cmdFull := []string{"\n  Video metadata\n", "  --------------\n"}
 	cmdFull = append(cmdFull, "     Duration: ")
-	cmdFull = append(cmdFull, fmt.Sprintf("%d", int64(vr.Duration.Seconds())), "\n")
+	if vr.UnknownDuration {
+		cmdFull = append(cmdFull, "N/A\n")
+	} else {
+		cmdFull = append(cmdFull, fmt.Sprintf("%d", int64(vr.Duration.Seconds())), "\n")
+	}
 	cmdFull = append(cmdFull, "  Size  (wxh): ", vr.VideoSize.String(), "\n")
+	if vr.Rotation != 0 {
+		cmdFull = append(cmdFull, "     Rotation: ", fmt.Sprintf("%d", vr.Rotation), "\n")
+	}
+	if vr.Anamorphic() {
+		cmdFull = append(cmdFull, "    SAR / DAR: ", vr.SampleAspect.String(),
+			" / ", vr.DisplayAspect.String(), "\n")
+	}
 	cmdFull = append(cmdFull, "  Fps (Hertz): ", fmt.Sprintf("%f", vr.Fps))
 	return strings.Join(cmdFull, "")
 }
 
 // Generates internally the temporary work directories
-// and other runtime constants etc.
+// and other runtime constants etc. The Workspace names them,
+// or a random number when there is none e.g. with -dry-run.
 // @TODO allow only one time execution
 func (v *VideoReader) Reset(size uint8) error {
-	return v.reset2(size,
-		func() string { return os.TempDir() },
+	err := v.reset2(size,
+		func() string { return workRoot(v.Root) },
 		func() string { return string(os.PathSeparator) },
-		func() int64 { t := time.Now(); return t.Unix() })
+		uniqueNum)
+	if err == nil && v.Workspace != nil {
+		v.TmpDir = v.Workspace.Dir
+		v.PngDir = filepath.Join(v.TmpDir, PDIR)
+	}
+	return err
 }
 
 // compromise: no method overloading
@@ -157,118 +341,175 @@ func (v *VideoReader) reset2(size uint8,
 }
 
 // Goal - Share memory by communicating
-type FrameGenerator struct{}
+type FrameGenerator struct {
+	Cancel <-chan struct{}
+}
 
-// Task #1: Generate all the frames as PNGs
+// Task #1: Generate all the frames as PNGs. Long windows are
+// split into segments extracted by concurrent ffmpegs.
 func (f FrameGenerator) Run(vr *VideoReader, args *util.Arguments) <-chan error {
 	cmdFull := f.prepCli(vr, args)
+	var cmds [][]string
+	if segs := f.segments(vr, args); len(segs) > 1 {
+		for _, seg := range segs {
+			cmds = append(cmds, f.segmentCli(vr, args, seg))
+		}
+		util.Log.Debug("extracting frames in segments",
+			"segments", len(segs), "parallel", args.Parallel)
+	}
 	reply := make(chan error)
 	go func() {
 		if args.DryRun {
-			fmt.Printf("  %s\n", cmdFull)
+			printWatermark(vr, args)
+			if cmds == nil {
+				vr.printf("  %s\n", cmdFull)
+			}
+			for _, cmd := range cmds {
+				vr.printf("  %s\n", cmd)
+			}
 			reply <- nil
 			return
 		}
 
 		if err := os.MkdirAll(vr.PngDir, os.ModePerm); err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to create %q\n\t%v\n", vr.PngDir, err)
+			util.Log.Error("unable to create directory", "dir", vr.PngDir,
+				"error", err)
 			reply <- err
 			return
 		}
 
-		cmd := exec.Command(ffmpegExec, cmdFull[1:]...)
-
-		if err := cmd.Start(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed executing %q\n\t%v\n", ffmpegExec, err)
-			reply <- err
-			return
-		}
-		if err := cmd.Wait(); err != nil {
-			fmt.Fprintf(os.Stderr, "%q executed with errors\n\t%v\n", ffmpegExec, err)
-			reply <- err
+		if cmds != nil {
+			reply <- f.runSegments(vr, cmds)
 			return
 		}
-		reply <- nil
+		reply <- execute(cmdFull, f.Cancel, vr.LogFile())
 	}()
 	return reply
 }
 
-func (f FrameGenerator) combineVf(args *util.Arguments) (bool, string) {
-	needSpeed := !util.IsEmpty(args.SpeedSpec)
-	var vfarg string
+// Stretches the frames of an anamorphic video to its display
+// aspect, as GIFs have no notion of non square pixels
+const SQUARE_PIXELS = "scale=trunc(iw*sar/2)*2:ih,setsar=1"
+
+// Whether the pixels are not square
+func (v *VideoReader) Anamorphic() bool {
+	return v.SampleAspect.Valid() && v.SampleAspect.Num != v.SampleAspect.Den
+}
+
+func (f FrameGenerator) combineVf(vr *VideoReader, args *util.Arguments) (bool, string) {
+	vf := orientFilters(vr, args)
 	switch {
 	case args.NeedScaling:
-		vfarg += args.ScaleFilter
-		if needSpeed {
-			vfarg += "," + args.SpeedSpec
-		}
-		return true, vfarg
-	case needSpeed:
-		vfarg += args.SpeedSpec
-		return true, vfarg
+		vf = append(vf, args.ScaleFilter)
+	case vr.Anamorphic():
+		vf = append(vf, SQUARE_PIXELS)
 	}
-	return false, ""
+	if !util.IsEmpty(args.SpeedSpec) {
+		vf = append(vf, args.SpeedSpec)
+	}
+	return len(vf) > 0, strings.Join(vf, ",")
 }
 
 func (f FrameGenerator) prepCli(vr *VideoReader, args *util.Arguments) []string {
-	cmdFull := []string{ffmpegExec, "-ss", args.From.String()}
+	cmdFull := append([]string{ffmpegExec}, vr.seek(args)...)
+
+	window := f.window(vr, args)
+	cmdFull = append(cmdFull, "-t", seconds(window))
+	cmdFull = append(cmdFull, vr.noAutorotate()...)
+	cmdFull = append(cmdFull, "-i", vr.Filename)
+	cmdFull = append(cmdFull, watermarkInput(args)...)
+	cmdFull = append(cmdFull, "-an")
+	cmdFull = append(cmdFull, f.filters(vr, args)...)
+
+	if args.Stream {
+		// uncompressed & self describing, see pipeInput
+		cmdFull = append(cmdFull, "-f", "image2pipe", "-c:v", "ppm")
+	} else {
+		cmdFull = append(cmdFull, "-q:v", "2", "-f", "image2")
+	}
+	cmdFull = append(cmdFull, "-vsync", "cfr")
+	cmdFull = append(cmdFull, "-r", fmt.Sprintf("%d", args.Fps), "-y")
+	cmdFull = append(cmdFull, "-progress", args.ProgressUrl())
+	vr.Reset(uint8(f.guess(window.Seconds(), args.Fps)))
+	if args.Stream {
+		cmdFull = append(cmdFull, "pipe:1")
+	} else {
+		cmdFull = append(cmdFull, filepath.Join(vr.PngDir, vr.TmpFile))
+	}
 
+	vr.Work.log()
+	return cmdFull
+}
+
+// The -length to extract, 3 secs when it is out of range.
+// Stages after it trim the Overscan past it. A long window is
+// extracted whole, by one ffmpeg or in segments.
+func (f FrameGenerator) window(vr *VideoReader, args *util.Arguments) time.Duration {
 	secs := args.Length.Seconds()
 	switch {
-	case secs < 60.0 && secs > 0.0:
-		cmdFull = append(cmdFull, "-t", fmt.Sprintf("%d", int64(secs)))
-	case args.Length > vr.Duration:
-		fallthrough
+	case secs > 0.0:
+		return args.Length + args.Overscan()
 	default:
-		fmt.Fprintf(os.Stderr, "WARNING: %d secs is outside of range. "+
-			"Forcing to 3 secs.\n", int64(secs))
-		cmdFull = append(cmdFull, "-t", "3")
+		util.Log.Warn("length is outside of range, forcing 3 secs",
+			"secs", int64(secs))
+		return 3*time.Second + args.Overscan()
 	}
+}
 
-	cmdFull = append(cmdFull, "-i", vr.Filename, "-an")
-
-	if vf, s := f.combineVf(args); vf {
-		cmdFull = append(cmdFull, "-vf", s)
-	}
-
-	cmdFull = append(cmdFull, "-q:v", "2", "-f", "image2", "-vsync", "cfr")
-	cmdFull = append(cmdFull, "-r", fmt.Sprintf("%d", args.Fps), "-y")
-	cmdFull = append(cmdFull, "-progress", fmt.Sprintf("http://127.0.0.1:%d",
-		args.Port))
-	vr.Reset(uint8(f.guess(secs)))
-	cmdFull = append(cmdFull, filepath.Join(vr.PngDir, vr.TmpFile))
-
-	if args.Verbose {
-		fmt.Printf("%s\n", vr.Work)
-	}
-	return cmdFull
+// An ffmpeg argument in seconds, fractional when the window
+// came from frame numbers or a segment
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
 }
 
 // Naive way to guess how many images are
-// captured per frames.
-func (f FrameGenerator) guess(secs float64) int {
+// captured per frames. Never too few digits for every frame of
+// the window, as the stages after it sort the names.
+func (f FrameGenerator) guess(secs float64, fps int) int {
+	width := 5
 	switch {
 	case secs > 0.0 && secs < 15.0:
-		return 3
+		width = 3
 	case secs >= 15.0 && secs < 30.0:
-		return 4
-	case secs >= 30.0 && secs < 60.0:
-		fallthrough
-	default:
-		return 5
+		width = 4
+	}
+	frames := strconv.Itoa(int(math.Ceil(secs * float64(fps))))
+	if len(frames) > width {
+		width = len(frames)
 	}
+	return width
 }
 
 // Goal - Communicate by sharing memory
 type Muxer struct {
-	err error
+	Cancel <-chan struct{}
+	err    error
 	sync.Mutex
 }
 
 func (m *Muxer) prepCli(vr *VideoReader, args *util.Arguments) []string {
 	cmdFull := []string{ffmpegExec, "-f", "image2", "-y"}
-	cmdFull = append(cmdFull, "-progress", fmt.Sprintf("http://127.0.0.1:%d",
-		args.Port))
+	cmdFull = append(cmdFull, "-progress", args.ProgressUrl())
+	if args.Stream {
+		cmdFull = []string{ffmpegExec, "-y", "-progress", args.ProgressUrl()}
+		cmdFull = append(cmdFull, pipeInput(args)...)
+		cmdFull = append(cmdFull, "-c:v", "libx264", "-crf", "23")
+		cmdFull = append(cmdFull, "-vf", "format=yuv420p")
+		cmdFull = append(cmdFull, "-preset", "veryslow")
+		cmdFull = append(cmdFull, filepath.Join(vr.TmpDir, TMPMP4))
+		return cmdFull
+	}
+	if args.Dedup {
+		// frame durations come from the concat script
+		cmdFull = []string{ffmpegExec, "-f", "concat", "-safe", "0", "-y"}
+		cmdFull = append(cmdFull, "-progress", args.ProgressUrl())
+		cmdFull = append(cmdFull, "-i", filepath.Join(vr.TmpDir, vr.Concat))
+		cmdFull = append(cmdFull, "-c:v", "libx264", "-crf", "23")
+		cmdFull = append(cmdFull, "-vf", "format=yuv420p", "-vsync", "vfr")
+		cmdFull = append(cmdFull, "-preset", "veryslow")
+		cmdFull = append(cmdFull, filepath.Join(vr.TmpDir, TMPMP4))
+		return cmdFull
+	}
 	cmdFull = append(cmdFull, "-i", filepath.Join(vr.PngDir, vr.TmpFile))
 	cmdFull = append(cmdFull, "-c:v", "libx264", "-crf", "23")
 	cmdFull = append(cmdFull, "-vf",
@@ -301,21 +542,12 @@ func (m *Muxer) Run(vr *VideoReader, args *util.Arguments) *sync.WaitGroup {
 	go func() {
 		defer wg.Done()
 		if args.DryRun {
-			fmt.Printf("  %s\n", cmdFull)
+			vr.printf("  %s\n", cmdFull)
 			return
 		}
 
-		cmd := exec.Command(ffmpegExec, cmdFull[1:]...)
-
-		if err := cmd.Start(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed executing %q\n\t%v\n", ffmpegExec, err)
+		if err := execute(cmdFull, m.Cancel, vr.LogFile()); err != nil {
 			m.setError(err)
-			return
-		}
-		if err := cmd.Wait(); err != nil {
-			fmt.Fprintf(os.Stderr, "%q executed with errors\n\t%v\n", ffmpegExec, err)
-			m.setError(err)
-			return
 		}
 	}()
 	return &wg
@@ -337,86 +569,97 @@ func (g *GifWriter) Run(vr *VideoReader, args *util.Arguments) {
 		defer g.Tombstone.Done()
 
 		if args.DryRun {
-			fmt.Printf("  %s\n", cmdFull)
+			vr.printf("  %s\n", cmdFull)
 			g.Tombstone.Kill(nil)
 			return
 		}
 
-		time.Sleep(2 * time.Second)
-
 		// Cooperative cancelation.
 		select {
 		case <-g.Tombstone.Dying():
-			fmt.Println("aborting")
+			util.Log.Debug("aborting", "gif", vr.Gif)
 			return
 		default:
 			// noop
 		}
 
-		cmd := exec.Command(ffmpegExec, cmdFull[1:]...)
-
-		if err := cmd.Start(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed executing %q\n\t%v\n", ffmpegExec, err)
+		if err := execute(cmdFull, g.Tombstone.Dying(), vr.LogFile()); err != nil {
 			g.Tombstone.Kill(err)
-			return
-		}
-		if err := cmd.Wait(); err != nil {
-			fmt.Fprintf(os.Stderr, "%q executed with errors\n\t%v\n", ffmpegExec, err)
-			g.Tombstone.Kill(err)
-			return
 		}
 	}()
 }
 
 func (g *GifWriter) prepCli(vr *VideoReader, args *util.Arguments) []string {
-	cmdFull := []string{ffmpegExec, "-i"}
-	cmdFull = append(cmdFull, filepath.Join(vr.TmpDir, TMPMP4))
-	cmdFull = append(cmdFull, "-progress", fmt.Sprintf("http://127.0.0.1:%d",
-		args.Port))
-	cmdFull = append(cmdFull, "-y", "-vf", "format=rgb24")
+	cmdFull := append([]string{ffmpegExec}, g.input(vr, args)...)
+	cmdFull = append(cmdFull, "-progress", args.ProgressUrl())
+	if args.UsePalette() {
+		cmdFull = append(cmdFull, "-y", "-filter_complex", g.palette(args))
+	} else {
+		cmdFull = append(cmdFull, "-y", "-vf", "format=rgb24")
+	}
+	if args.Dedup {
+		cmdFull = append(cmdFull, "-vsync", "vfr")
+	}
 	cmdFull = append(cmdFull, filepath.Join(vr.TmpDir, vr.Gif))
 	return cmdFull
 }
 
+// temp.mp4 made by the Muxer, or with -direct the frames
+// themselves (through the concat script after -dedup)
+func (g *GifWriter) input(vr *VideoReader, args *util.Arguments) []string {
+	switch {
+	case !args.Direct:
+		return []string{"-i", filepath.Join(vr.TmpDir, TMPMP4)}
+	case args.Stream:
+		return pipeInput(args)
+	case args.Dedup:
+		return []string{"-f", "concat", "-safe", "0",
+			"-i", filepath.Join(vr.TmpDir, vr.Concat)}
+	default:
+		return []string{"-f", "image2", "-framerate",
+			fmt.Sprintf("%d", args.Fps),
+			"-i", filepath.Join(vr.PngDir, vr.TmpFile)}
+	}
+}
+
+// The frames streamed by FrameGenerator with -stream
+func pipeInput(args *util.Arguments) []string {
+	return []string{"-f", "image2pipe", "-framerate",
+		fmt.Sprintf("%d", args.Fps), "-i", "pipe:0"}
+}
+
+// One pass: the frames are split, one copy generates the
+// palette that the other is mapped onto.
+func (g *GifWriter) palette(args *util.Arguments) string {
+	stats, use := args.PaletteStats, []string{"dither=" + args.Dither}
+	if args.PalettePerFrame {
+		stats, use = "single", append(use, "new=1")
+	}
+	if args.Dither == "bayer" {
+		use = append(use, fmt.Sprintf("bayer_scale=%d", args.BayerScale))
+	}
+	return fmt.Sprintf("split[a][b];"+
+		"[a]palettegen=max_colors=%d:stats_mode=%s[p];"+
+		"[b][p]paletteuse=%s", args.Colors, stats, strings.Join(use, ":"))
+}
+
 // getMetadata parses output of `ffprobe` into a VideoReader
-func getMetadata(videoFile string, dryRun bool) (*VideoReader, error) {
+func getMetadata(videoFile string, dryRun bool, out stdio.Writer) (*VideoReader, error) {
+	if util.IsEmpty(ffprobeExec) {
+		return nil, ErrNotConfigured
+	}
 	cmdFull := []string{ffprobeExec, videoFile}
 	if dryRun {
-		fmt.Printf("  %s\n", cmdFull)
+		fmt.Fprintf(stdout(out), "  %s\n", cmdFull)
 	}
-	cmd := exec.Command(ffprobeExec, cmdFull[1:]...)
-	stderr, err := cmd.StderrPipe()
+	var data bytes.Buffer
+	proc, err := currentRunner().Start(cmdFull, &data)
 	if err != nil {
 		return nil, err
 	}
-
-	if err = cmd.Start(); err != nil {
-		return nil, err
-	}
-
-	var (
-		data bytes.Buffer
-		n    int
-	)
-	for {
-		n = 0
-		err = nil
-		tmp := make([]byte, CHUNK)
-		n, err = stdio.ReadFull(stderr, tmp)
-		if err == nil {
-			data.Write(tmp)
-		} else {
-			if err == stdio.ErrUnexpectedEOF {
-				if n > 0 {
-					tmp = tmp[0:n]
-					data.Write(tmp)
-				}
-				break
-			}
-		}
-	}
-
-	if err = cmd.Wait(); err != nil {
+	if err = proc.Wait(); err != nil {
+		err = classify(cmdFull, tailOf(data.Bytes()), err)
+		logStderr(cmdFull, tailOf(data.Bytes()))
 		return nil, err
 	}
 
@@ -425,9 +668,17 @@ func getMetadata(videoFile string, dryRun bool) (*VideoReader, error) {
 }
 
 func NewVideoReader(filename string, dryRun bool) (vr *VideoReader, err error) {
-	vr, err = getMetadata(filename, dryRun)
+	return NewVideoReaderTo(filename, dryRun, nil)
+}
+
+// Like NewVideoReader, with -dry-run printing to out
+func NewVideoReaderTo(filename string, dryRun bool,
+	out stdio.Writer) (vr *VideoReader, err error) {
+
+	vr, err = getMetadata(filename, dryRun, out)
 	if err == nil {
 		vr.Filename = filename
+		vr.Out = out
 	}
 	return
 }
@@ -3,9 +3,12 @@ package io
 import (
 	"github.com/javouhey/seneca/util"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestVideoReader(t *testing.T) {
@@ -56,9 +59,205 @@ func TestVfArgs(t *testing.T) {
 		a.SpeedSpec = tt.SpeedSpec
 		a.NeedScaling = tt.NeedScaling
 		a.ScaleFilter = tt.ScaleFilter
-		b, s := fg.combineVf(a)
+		b, s := fg.combineVf(&VideoReader{}, a)
 		if b != tt.vf || s != tt.out {
 			t.Errorf("%d. Error out(%t), want %t // out(%q), want %q", i, b, tt.vf, s, tt.out)
 		}
 	}
 }
+
+func TestOrientFilters(t *testing.T) {
+	vr := &VideoReader{Rotation: 90}
+	a := util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-scale", "200:_"}))
+	assert.Equal(t, []string{"transpose=clock"}, orientFilters(vr, a))
+	_, vf := new(FrameGenerator).combineVf(vr, a)
+	assert.Equal(t, "transpose=clock,"+a.ScaleFilter, vf)
+
+	a.Rotate, a.Flip = 90, "h"
+	assert.Equal(t, []string{"hflip", "vflip", "hflip"}, orientFilters(vr, a))
+	a.Rotate = 270
+	assert.Equal(t, []string{"hflip"}, orientFilters(vr, a))
+	a.NoAutorotate, a.Flip = true, "v"
+	assert.Equal(t, []string{"transpose=cclock", "vflip"},
+		orientFilters(vr, a))
+	assert.Equal(t, []string{"-noautorotate"}, vr.noAutorotate())
+
+	vr.Rotation = 0
+	assert.Nil(t, vr.noAutorotate())
+
+	// DV NTSC widescreen, stretched unless -scale says otherwise
+	vr.SampleAspect = util.Rational{Num: 32, Den: 27}
+	assert.True(t, vr.Anamorphic())
+	_, vf = new(FrameGenerator).combineVf(vr, util.NewArguments())
+	assert.Equal(t, SQUARE_PIXELS, vf)
+	_, vf = new(FrameGenerator).combineVf(vr, a)
+	assert.Equal(t, "transpose=cclock,vflip,"+a.ScaleFilter, vf)
+	assert.Nil(t, orientFilters(vr, util.NewArguments()))
+}
+
+func TestContactSheetCli(t *testing.T) {
+	vr := &VideoReader{Filename: "/videos/plane.mp4", Duration: 100 * time.Second}
+	a := util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-sheet", "4x3", "-sheet-labels",
+		"-from", "00:00:40"}))
+
+	var c ContactSheet
+	assert.Equal(t, 60*time.Second, c.window(vr, a))
+	assert.Equal(t, "fps=12/60,scale=320:trunc(ow/dar/2)*2,setsar=1,"+
+		"drawtext=text='%{pts\\:hms\\:40}':x=4:y=h-th-4:fontcolor=white"+
+		":box=1:boxcolor=black@0.5,tile=4x3", c.filters(vr, a))
+
+	cmd := c.prepCli(vr, a)
+	assert.Equal(t, "plane-sheet.png", vr.Sheet)
+	assert.Equal(t, filepath.Join(vr.TmpDir, vr.Sheet), cmd[len(cmd)-1])
+
+	a = util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-sheet", "2x2", "-length", "8s",
+		"-scale", "_:120"}))
+	assert.Equal(t, 8*time.Second, c.window(vr, a))
+	assert.Equal(t, "fps=4/8,scale=trunc(oh*dar/2)*2:120,setsar=1,tile=2x2",
+		c.filters(vr, a))
+}
+
+func TestUnknownDuration(t *testing.T) {
+	vr := &VideoReader{Filename: "/videos/live.flv", UnknownDuration: true}
+	a := util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-sheet", "2x2", "-from", "01:00:00"}))
+
+	_, known := vr.Remaining(a.From.Offset())
+	assert.False(t, known)
+	assert.NoError(t, vr.CheckWindow(a))
+	var c ContactSheet
+	assert.Equal(t, a.Length, c.window(vr, a))
+	assert.Equal(t, []string{"-ss", "01:00:00"}, vr.seek(a))
+
+	vr = &VideoReader{Filename: "/videos/plane.mp4", Duration: 100 * time.Second}
+	assert.Error(t, vr.CheckWindow(a))
+
+	a = util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-from", "now"}))
+	assert.True(t, a.FromNow)
+	assert.NoError(t, vr.CheckWindow(a))
+	assert.Equal(t, []string{"-follow", "1", "-ss", "00:01:40"}, vr.seek(a))
+
+	vr.UnknownDuration = true
+	assert.Equal(t, []string{"-follow", "1"}, vr.seek(a))
+}
+
+func TestLongWindow(t *testing.T) {
+	vr := &VideoReader{Filename: "/videos/plane.mp4", Duration: 300 * time.Second,
+		Rate: util.Rational{Num: 30, Den: 1}}
+	var f FrameGenerator
+
+	for _, parallel := range []string{"1", "4"} {
+		a := util.NewArguments()
+		assert.NoError(t, a.Parse([]string{"-length", "90s", "-parallel", parallel}))
+		assert.Equal(t, 90*time.Second, f.window(vr, a))
+
+		// 1800 frames at 30 fps span 60s
+		a = util.NewArguments()
+		assert.NoError(t, a.Parse([]string{"-from-frame", "30", "-frames", "1800",
+			"-parallel", parallel}))
+		assert.NoError(t, vr.ResolveWindow(a))
+		assert.Equal(t, 60*time.Second, f.window(vr, a))
+	}
+	a := util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-length", "0s"}))
+	assert.Equal(t, 3*time.Second, f.window(vr, a))
+}
+
+func TestGuessWidth(t *testing.T) {
+	var f FrameGenerator
+	assert.Equal(t, 3, f.guess(3, 30))
+	assert.Equal(t, 4, f.guess(20, 30))
+	assert.Equal(t, 5, f.guess(90, 30))
+	// 108000 frames need 6 digits to sort in order
+	assert.Equal(t, 6, f.guess(3600, 30))
+}
+
+func TestOverscanWindow(t *testing.T) {
+	vr := &VideoReader{Filename: "/videos/plane.mp4", Duration: 60 * time.Second}
+	a := util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-from", "00:00:56", "-length", "3s",
+		"-smooth-loop", "1s"}))
+	assert.NoError(t, vr.CheckWindow(a))
+
+	a = util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-from", "00:00:56", "-length", "3s",
+		"-smooth-loop", "1s", "-auto-loop", "1s"}))
+	assert.Error(t, vr.CheckWindow(a))
+
+	// nothing is read past -length without them
+	a = util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-from", "00:00:58", "-length", "3s"}))
+	assert.NoError(t, vr.CheckWindow(a))
+
+	vr.UnknownDuration = true
+	a = util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-from", "00:00:58", "-length", "3s",
+		"-auto-loop", "1s"}))
+	assert.NoError(t, vr.CheckWindow(a))
+}
+
+func TestGifPalette(t *testing.T) {
+	vr := &VideoReader{Filename: "/videos/plane.mp4", Gif: "plane.gif"}
+	var g GifWriter
+
+	a := util.NewArguments()
+	assert.NoError(t, a.Parse([]string{}))
+	assert.Contains(t, g.prepCli(vr, a), "format=rgb24")
+
+	a = util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-colors", "32", "-dither", "bayer:3"}))
+	assert.Equal(t, "split[a][b];"+
+		"[a]palettegen=max_colors=32:stats_mode=full[p];"+
+		"[b][p]paletteuse=dither=bayer:bayer_scale=3", g.palette(a))
+	assert.Contains(t, g.prepCli(vr, a), "-filter_complex")
+
+	a = util.NewArguments()
+	assert.NoError(t, a.Parse([]string{"-palette-per-frame"}))
+	assert.Equal(t, "split[a][b];"+
+		"[a]palettegen=max_colors=256:stats_mode=single[p];"+
+		"[b][p]paletteuse=dither=sierra2_4a:new=1", g.palette(a))
+}
+
+func fakeProgram(t *testing.T, dir, name string) string {
+	prog := filepath.Join(dir, name)
+	err := ioutil.WriteFile(prog, []byte("#!/bin/sh\nexit 0\n"), 0755)
+	assert.NoError(t, err)
+	return prog
+}
+
+func TestConfigure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts as fake programs")
+	}
+	tmp, err := ioutil.TempDir("", "seneca-programs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	probe := fakeProgram(t, tmp, "my-ffprobe")
+	mpeg := fakeProgram(t, tmp, "my-ffmpeg")
+	defer func(a, b string) { ffmpegExec, ffprobeExec = a, b }(ffmpegExec, ffprobeExec)
+
+	assert.NoError(t, Configure(Programs{Ffmpeg: mpeg, Ffprobe: probe}))
+	assert.Equal(t, mpeg, ffmpegExec)
+	assert.Equal(t, probe, ffprobeExec)
+
+	// environment is consulted for empty fields
+	os.Setenv(ENV_FFMPEG, mpeg)
+	defer os.Unsetenv(ENV_FFMPEG)
+	ffmpegExec = ""
+	assert.NoError(t, Configure(Programs{Ffprobe: probe}))
+	assert.Equal(t, mpeg, ffmpegExec)
+
+	err = Configure(Programs{Ffmpeg: mpeg, Ffprobe: filepath.Join(tmp, "nope")})
+	if assert.Error(t, err) {
+		perr, ok := err.(*ProgramError)
+		assert.True(t, ok)
+		assert.Equal(t, "ffprobe", perr.Program)
+	}
+	// a failed Configure leaves the previous programs in place
+	assert.Equal(t, probe, ffprobeExec)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdio "io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/javouhey/seneca/util"
+)
+
+var ErrInputTooLarge = errors.New("input exceeds the size limit")
+
+// Stands in for an extension that cannot be derived
+const SPOOL_EXT = ".video"
+
+// A url must connect & answer within these. The body itself has
+// no deadline, only cancel stops a slow download.
+const (
+	URL_CONNECT_TIMEOUT = 30 * time.Second
+	URL_HEADER_TIMEOUT  = 30 * time.Second
+)
+
+var urlClient = &http.Client{Transport: &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   URL_CONNECT_TIMEOUT,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	TLSHandshakeTimeout:   URL_CONNECT_TIMEOUT,
+	ResponseHeaderTimeout: URL_HEADER_TIMEOUT,
+}}
+
+var rgxUnsafeName = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// Where a video is read from. Stdin & URLs are spooled into a
+// workspace of their own because probing & seeking need a file.
+type Input struct {
+	Source string // as given to -video-infile
+	File   string // local path handed to ffprobe & ffmpeg
+
+	spool *Workspace
+}
+
+// OpenInput makes source available as a local file. At most limit
+// bytes are read from stdin or an http(s) URL. Closing cancel
+// aborts a download with ErrCancelled.
+func OpenInput(source, root string, limit int64,
+	cancel <-chan struct{}) (*Input, error) {
+
+	in := &Input{Source: source}
+	switch {
+	case util.IsStdin(source):
+		return in, in.fetch(os.Stdin, "stdin"+SPOOL_EXT, root, limit)
+
+	case util.IsUrl(source):
+		err := in.download(source, root, limit, cancel)
+		if err != nil {
+			return nil, err
+		}
+		return in, nil
+
+	default:
+		file, err := util.SanitizeFile(source)
+		if err != nil {
+			return nil, err
+		}
+		in.File = file
+		return in, nil
+	}
+}
+
+func (in *Input) download(source, root string, limit int64,
+	cancel <-chan struct{}) error {
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go func() {
+		select {
+		case <-cancel:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+	aborted := func(err error) error {
+		if ctx.Err() != nil {
+			return ErrCancelled
+		}
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := urlClient.Do(req)
+	if err != nil {
+		return aborted(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+	if resp.ContentLength > limit {
+		return ErrInputTooLarge
+	}
+	return aborted(in.fetch(resp.Body, UrlName(source), root, limit))
+}
+
+func (in *Input) fetch(r stdio.Reader, name, root string, limit int64) error {
+	ws, err := NewWorkspace(root)
+	if err != nil {
+		return err
+	}
+	in.spool = ws
+	in.File = filepath.Join(ws.Dir, name)
+
+	f, err := os.Create(in.File)
+	if err != nil {
+		in.Remove()
+		return err
+	}
+	n, err := stdio.Copy(f, stdio.LimitReader(r, limit+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = ErrInputTooLarge
+	}
+	if err != nil {
+		in.Remove()
+		return err
+	}
+	util.Log.Debug("spooled input", "source", in.Source, "file", in.File,
+		"bytes", n)
+	return nil
+}
+
+// Remove deletes the spooled copy, if any
+func (in *Input) Remove() error {
+	if in == nil || in.spool == nil {
+		return nil
+	}
+	return in.spool.Remove()
+}
+
+// UrlName derives a file name from the last element of the path
+// of a URL e.g. https://ci/builds/42/demo.mp4?dl=1 becomes demo.mp4
+// and https://example.com/ becomes example.video
+func UrlName(source string) string {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "video" + SPOOL_EXT
+	}
+	base := path.Base(u.Path)
+	if base == "." || base == "/" {
+		base = u.Host
+		if i := strings.Index(base, "."); i > 0 {
+			base = base[:i]
+		}
+		base += SPOOL_EXT
+	}
+
+	ext := path.Ext(base)
+	name := rgxUnsafeName.ReplaceAllString(strings.TrimSuffix(base, ext), "_")
+	ext = rgxUnsafeName.ReplaceAllString(strings.TrimPrefix(ext, "."), "")
+	if util.IsEmpty(strings.Trim(name, "_")) {
+		name = "video"
+	}
+	if util.IsEmpty(ext) {
+		ext = strings.TrimPrefix(SPOOL_EXT, ".")
+	}
+	return name + "." + ext
+}
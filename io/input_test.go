@@ -0,0 +1,140 @@
+package io
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var urlNameFixtures = []struct {
+	in  string
+	out string
+}{
+	{"https://ci/builds/42/demo.mp4?dl=1", "demo.mp4"},
+	{"http://example.com/", "example.video"},
+	{"http://example.com", "example.video"},
+	{"http://example.com/watch", "watch.video"},
+	{"http://example.com/a%20clip%21.mov", "a_clip_.mov"},
+	{"http://example.com/.mp4", "video.mp4"},
+}
+
+func TestUrlName(t *testing.T) {
+	for _, f := range urlNameFixtures {
+		assert.Equal(t, f.out, UrlName(f.in), f.in)
+	}
+}
+
+func videoServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/builds/demo.mp4":
+				w.Write([]byte(body))
+			case "/chunked.mp4":
+				// no Content-Length
+				w.(http.Flusher).Flush()
+				w.Write([]byte(body))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+}
+
+func TestOpenInputUrl(t *testing.T) {
+	root, err := ioutil.TempDir("", "seneca-input")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	ts := videoServer("not really a video")
+	defer ts.Close()
+
+	in, err := OpenInput(ts.URL+"/builds/demo.mp4", root, 1<<20, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "demo.mp4", filepath.Base(in.File))
+		data, _ := ioutil.ReadFile(in.File)
+		assert.Equal(t, "not really a video", string(data))
+		assert.NoError(t, in.Remove())
+		_, err = os.Stat(in.File)
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	for _, path := range []string{"/builds/demo.mp4", "/chunked.mp4"} {
+		_, err = OpenInput(ts.URL+path, root, 4, nil)
+		assert.Equal(t, ErrInputTooLarge, err, path)
+	}
+
+	_, err = OpenInput(ts.URL+"/missing.mp4", root, 1<<20, nil)
+	assert.Error(t, err)
+
+	// nothing is left behind by the failures
+	left, _ := ioutil.ReadDir(filepath.Join(root, APPDIR))
+	assert.Empty(t, left)
+}
+
+func TestOpenInputUrlCancel(t *testing.T) {
+	root, err := ioutil.TempDir("", "seneca-input")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	// sends part of the body, then stalls
+	stalled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-stalled:
+			case <-r.Context().Done():
+			}
+		}))
+	defer ts.Close()
+	defer close(stalled)
+
+	cancel := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(cancel) })
+	start := time.Now()
+	_, err = OpenInput(ts.URL+"/slow.mp4", root, 1<<20, cancel)
+	assert.Equal(t, ErrCancelled, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	left, _ := ioutil.ReadDir(filepath.Join(root, APPDIR))
+	assert.Empty(t, left)
+}
+
+func TestOpenInputStdin(t *testing.T) {
+	root, err := ioutil.TempDir("", "seneca-input")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	stdin := filepath.Join(root, "stdin")
+	ioutil.WriteFile(stdin, []byte(strings.Repeat("x", 100)), 0644)
+	f, _ := os.Open(stdin)
+	defer f.Close()
+	saved := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = saved }()
+
+	in, err := OpenInput("-", root, 1<<20, nil)
+	if assert.NoError(t, err) {
+		defer in.Remove()
+		assert.Equal(t, "stdin"+SPOOL_EXT, filepath.Base(in.File))
+		fi, _ := os.Stat(in.File)
+		assert.Equal(t, int64(100), fi.Size())
+	}
+}
+
+func TestOpenInputFile(t *testing.T) {
+	in, err := OpenInput("input_test.go", "", 1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "input_test.go", in.File)
+	assert.NoError(t, in.Remove())
+
+	_, err = OpenInput("no-such.mp4", "", 1, nil)
+	assert.Error(t, err)
+}
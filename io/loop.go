@@ -0,0 +1,142 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/javouhey/seneca/util"
+)
+
+// Crossfades the end of the frames in vr.PngDir into their
+// start for -smooth-loop. FrameGenerator extracted that much
+// more than -length; those last frames are blended into the
+// first ones & removed, so the numbering stays contiguous.
+type LoopSmoother struct{}
+
+// How many frames fade: those of the -smooth-loop, but never
+// more than half of them
+func fadeFrames(total int, loop float64, fps int) int {
+	n := int(math.Round(loop * float64(fps)))
+	if n > total/2 {
+		n = total / 2
+	}
+	return n
+}
+
+// Head weighs w, tail 1-w, on premultiplied 16 bit channels
+func blend(tail, head image.Image, w float64) (*image.RGBA64, error) {
+	b := head.Bounds()
+	if tail.Bounds().Size() != b.Size() {
+		return nil, fmt.Errorf("frames differ in size: %v & %v",
+			tail.Bounds().Size(), b.Size())
+	}
+	tb := tail.Bounds()
+	mix := func(t, h uint32) uint16 {
+		return uint16(math.Round(float64(t)*(1-w) + float64(h)*w))
+	}
+	out := image.NewRGBA64(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			tr, tg, tbl, ta := tail.At(tb.Min.X+x, tb.Min.Y+y).RGBA()
+			hr, hg, hbl, ha := head.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			out.SetRGBA64(b.Min.X+x, b.Min.Y+y, color.RGBA64{
+				mix(tr, hr), mix(tg, hg), mix(tbl, hbl), mix(ta, ha)})
+		}
+	}
+	return out, nil
+}
+
+func decodeFile(file string) (image.Image, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return png.Decode(fh)
+}
+
+func encodeFile(file string, img image.Image) error {
+	fh, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(fh, img)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// a priori: FrameGenerator task was executed without errors
+func (l LoopSmoother) Run(vr *VideoReader, args *util.Arguments) <-chan error {
+	reply := make(chan error, 1)
+	go func() {
+		if args.DryRun {
+			vr.printf("  fade the last %s of %s into the first\n",
+				args.SmoothLoop, filepath.Join(vr.PngDir, "*.png"))
+			reply <- nil
+			return
+		}
+		reply <- l.smooth(vr, args)
+	}()
+	return reply
+}
+
+func (l LoopSmoother) smooth(vr *VideoReader, args *util.Arguments) error {
+	files, err := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	n := fadeFrames(len(files), args.SmoothLoop.Seconds(), args.Fps)
+	keep := len(files) - n
+	for i := 0; i < n; i++ {
+		head, err := decodeFile(files[i])
+		if err != nil {
+			return err
+		}
+		tail, err := decodeFile(files[keep+i])
+		if err != nil {
+			return err
+		}
+		// the tail fades out as the loop comes round
+		img, err := blend(tail, head, float64(i+1)/float64(n+1))
+		if err != nil {
+			return err
+		}
+		if err := encodeFile(files[i], img); err != nil {
+			return err
+		}
+	}
+	for _, file := range files[keep:] {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	util.Log.Debug("smooth loop", "faded", n, "frames", keep)
+	return nil
+}
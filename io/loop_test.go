@@ -0,0 +1,32 @@
+package io
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFadeFrames(t *testing.T) {
+	assert.Equal(t, 5, fadeFrames(30, 0.5, 10))
+	assert.Equal(t, 3, fadeFrames(7, 1.0, 10))
+	assert.Equal(t, 0, fadeFrames(30, 0.0, 10))
+}
+
+func TestBlend(t *testing.T) {
+	img, err := blend(solid(0), solid(200), 0.25)
+	assert.NoError(t, err)
+	r, _, _, a := img.At(10, 10).RGBA()
+	assert.Equal(t, uint32(50*0x101), r)
+	assert.Equal(t, uint32(0xffff), a)
+
+	clear := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	img, err = blend(clear, solid(255), 0.5)
+	assert.NoError(t, err)
+	assert.Equal(t, color.RGBA64{0x8000, 0x8000, 0x8000, 0x8000},
+		img.At(0, 0))
+
+	_, err = blend(image.NewGray(image.Rect(0, 0, 8, 8)), solid(0), 0.5)
+	assert.Error(t, err)
+}
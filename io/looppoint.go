@@ -0,0 +1,156 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/javouhey/seneca/util"
+)
+
+// Frames are numbered from 0 in the order FrameGenerator wrote
+// them. The GIF is Start up to but excluding End, so that it
+// wraps around from End-1 to Start as it would have to End.
+type LoopPoint struct {
+	Start int
+	End   int
+	Score float64 // Distance between frames End & Start
+}
+
+var ErrTooFewFrames = errors.New("too few frames to find a loop point")
+
+// The End closest to nominal with the lowest Score, End within
+// search frames of nominal. With moveStart, Start may move as
+// far as search frames into the clip.
+func findLoop(fps []*Fingerprint, nominal, search int,
+	moveStart bool) (LoopPoint, error) {
+
+	best := LoopPoint{Score: math.Inf(1)}
+	starts := 0
+	if moveStart {
+		starts = search
+	}
+	for s := 0; s <= starts; s++ {
+		// at least 2 frames in the GIF
+		first := nominal - search
+		if first < s+2 {
+			first = s + 2
+		}
+		for e := first; e <= nominal+search && e < len(fps); e++ {
+			score := fps[e].Distance(fps[s])
+			if score < best.Score || (score == best.Score &&
+				abs(e-s-nominal) < abs(best.End-best.Start-nominal)) {
+				best = LoopPoint{s, e, score}
+			}
+		}
+	}
+	if math.IsInf(best.Score, 1) {
+		return best, ErrTooFewFrames
+	}
+	return best, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Trims the frames in vr.PngDir to the best LoopPoint for
+// -auto-loop, then moves -from & -length to match
+type LoopFinder struct{}
+
+// a priori: FrameGenerator task was executed without errors
+func (l LoopFinder) Run(vr *VideoReader, args *util.Arguments) <-chan error {
+	reply := make(chan error, 1)
+	go func() {
+		if args.DryRun {
+			vr.printf("  find the loop point of %s within %s of -length\n",
+				filepath.Join(vr.PngDir, "*.png"), args.AutoLoop)
+			reply <- nil
+			return
+		}
+		reply <- l.find(vr, args)
+	}()
+	return reply
+}
+
+func (l LoopFinder) find(vr *VideoReader, args *util.Arguments) error {
+	files, err := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	fps := make([]*Fingerprint, len(files))
+	for i, file := range files {
+		if fps[i], err = fingerprintFile(file); err != nil {
+			util.Log.Error("unable to read frame", "file", file, "error", err)
+			return err
+		}
+	}
+
+	// FrameGenerator extracted search frames past -length
+	search := int(math.Round(args.AutoLoop.Seconds() * float64(args.Fps)))
+	lp, err := findLoop(fps, len(files)-search, search, args.AutoLoopStart)
+	if err != nil {
+		return err
+	}
+	if err := l.trim(vr, files, lp); err != nil {
+		return err
+	}
+
+	frames := func(n int) time.Duration {
+		return time.Duration(n) * time.Second / time.Duration(args.Fps)
+	}
+	args.From = util.NewTimeCode(args.From.Offset() + frames(lp.Start))
+	args.Length = frames(lp.End - lp.Start)
+	vr.Loop = &lp
+	util.Log.Info("auto-loop picked a loop point", "from", args.From,
+		"length", args.Length, "score", fmt.Sprintf("%.2f", lp.Score))
+	return nil
+}
+
+// Keeps files[Start:End] renumbered from 1
+func (l LoopFinder) trim(vr *VideoReader, files []string, lp LoopPoint) error {
+	for i, file := range files {
+		if i >= lp.Start && i < lp.End {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	if lp.Start == 0 {
+		return nil
+	}
+	for i, file := range files[lp.Start:lp.End] {
+		name := filepath.Join(vr.PngDir, fmt.Sprintf(vr.TmpFile, i+1))
+		if err := os.Rename(file, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
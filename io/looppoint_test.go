@@ -0,0 +1,32 @@
+package io
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindLoop(t *testing.T) {
+	var fps []*Fingerprint
+	// a ramp repeating every 7 frames, with a dark first frame
+	for i, c := range []uint8{5, 40, 80, 120, 160, 200, 240,
+		10, 40, 80, 120, 160, 200, 240, 10, 40} {
+		if i == 0 {
+			c = 0
+		}
+		fps = append(fps, NewFingerprint(solid(c)))
+	}
+
+	lp, err := findLoop(fps, 12, 3, false)
+	assert.NoError(t, err)
+	assert.Equal(t, LoopPoint{0, 14, 10.0 * 100 / 255}, lp)
+
+	lp, err = findLoop(fps, 12, 3, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, lp.Start)
+	assert.Equal(t, 15, lp.End)
+	assert.Equal(t, 0.0, lp.Score)
+
+	_, err = findLoop(fps[:2], 1, 1, false)
+	assert.Equal(t, ErrTooFewFrames, err)
+}
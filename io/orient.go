@@ -0,0 +1,76 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"github.com/javouhey/seneca/util"
+)
+
+// Clockwise degrees the frames are turned by: the rotation the
+// video is tagged with, unless -no-autorotate, then -rotate
+func (v *VideoReader) turn(args *util.Arguments) int {
+	turn := args.Rotate
+	if !args.NoAutorotate {
+		turn += v.Rotation
+	}
+	return turn % 360
+}
+
+// Size & aspect ratios of the frames orientFilters turned, e.g.
+// 1080x1920 for a 1920x1080 video tagged with rotate=90 unless
+// -no-autorotate
+func (v *VideoReader) Oriented(args *util.Arguments) (VideoSize,
+	util.Rational, util.Rational) {
+
+	size, sar, dar := v.VideoSize, v.SampleAspect, v.DisplayAspect
+	if v.turn(args)%180 != 0 {
+		size = VideoSize{size.Height, size.Width}
+		sar, dar = inverse(sar), inverse(dar)
+	}
+	return size, sar, dar
+}
+
+// Input options of a tagged video. ffmpeg's own autorotation
+// is off so that orientFilters alone decides.
+func (v *VideoReader) noAutorotate() []string {
+	if v.Rotation == 0 {
+		return nil
+	}
+	return []string{"-noautorotate"}
+}
+
+// Filters turning the frames upright & then flipping them,
+// ahead of everything else so -scale applies to the result
+func orientFilters(vr *VideoReader, args *util.Arguments) []string {
+	var vf []string
+	switch vr.turn(args) {
+	case 90:
+		vf = append(vf, "transpose=clock")
+	case 180:
+		vf = append(vf, "hflip", "vflip")
+	case 270:
+		vf = append(vf, "transpose=cclock")
+	}
+	switch args.Flip {
+	case "h":
+		vf = append(vf, "hflip")
+	case "v":
+		vf = append(vf, "vflip")
+	}
+	return vf
+}
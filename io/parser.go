@@ -23,7 +23,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -36,12 +36,19 @@ import (
 const (
 	sVideo    = "Video:"
 	sDuration = "Duration:"
+
+	sRotate        = "rotate"
+	sDisplayMatrix = "displaymatrix:"
 )
 
 var (
 	// Duration: 00:08:20.53, start: 0.000000, bitrate: 709 kb/s
 	Regex1 = regexp.MustCompile(`^Duration: (?P<duration>\d{2}:\d{2}:\d{2}).\d{2}(.*)$`)
 
+	// Duration: N/A, start: 1503.400000, bitrate: N/A
+	RegexDurationNA = regexp.MustCompile(`^Duration: N/A`)
+	RegexStart      = regexp.MustCompile(`start: (?P<start>-?\d+(\.\d+)?)`)
+
 	// .. yuv420p, 960x720 [SAR 1:1 DAR 4:3], ..
 	Regex2 = regexp.MustCompile(`^(?P<prefix>.*?)(?P<size>\d{3,}?x\d{2,}?)([,\s])(?P<postfix>.*)$`)
 
@@ -49,15 +56,46 @@ var (
 	RegexFps1 = regexp.MustCompile(`^(?P<prefix>.*?)(?P<fps>\d{1,}\.?\d* fps,)(?P<postfix>.*)$`)
 	RegexFps2 = regexp.MustCompile(`^(?P<prefix>.*?)(?P<tbr>\d{1,}\.?\d* tbr,)(?P<postfix>.*)$`)
 
+	// .. 720x576 [SAR 64:45 DAR 16:9], ..
+	RegexAspect = regexp.MustCompile(`\[SAR (?P<sar>\d+:\d+) DAR (?P<dar>\d+:\d+)\]`)
+
+	// rotate          : 90
+	// displaymatrix: rotation of -90.00 degrees
+	RegexRotate        = regexp.MustCompile(`^rotate\s*: (?P<rotate>-?\d+)$`)
+	RegexDisplayMatrix = regexp.MustCompile(`^displaymatrix: rotation of (?P<degrees>-?\d+(\.\d+)?) degrees`)
+
 	InvalidDuration  = errors.New("Duration input is invalid")
+	DurationNA       = errors.New("Duration is N/A")
+	InvalidStart     = errors.New("Cannot parse for start")
 	InvalidVideoSize = errors.New("Cannot parse for WxH")
 	InvalidFps       = errors.New("Cannot parse for fps/tbr")
+	InvalidRotation  = errors.New("Cannot parse for rotation")
+	InvalidAspect    = errors.New("Cannot parse for SAR/DAR")
 )
 
 func ParseFps(raw string) (float32, error) {
 	empty := float32(0.00)
+	text, err := fpsText(raw)
+	if err != nil {
+		return empty, err
+	}
+	f, _ := strconv.ParseFloat(text, 32)
+	return float32(f), nil
+}
+
+// Same as ParseFps but exact e.g. 30000/1001 for 29.97
+func ParseFrameRate(raw string) (util.Rational, error) {
+	text, err := fpsText(raw)
+	if err != nil {
+		return util.Rational{}, err
+	}
+	return util.ParseRate(text)
+}
+
+// The number before fps, or else tbr
+func fpsText(raw string) (string, error) {
 	if util.IsEmpty(raw) {
-		return empty, InvalidFps
+		return "", InvalidFps
 	}
 
 	raw = strings.TrimSpace(raw)
@@ -65,27 +103,19 @@ func ParseFps(raw string) (float32, error) {
 	fps1 := RegexFps1.MatchString(raw)
 	fps2 := RegexFps2.MatchString(raw)
 
-	if !fps1 && !fps2 {
-		return empty, InvalidFps
-	}
-
 	if fps1 {
 		matched := RegexFps1.ReplaceAllString(raw,
 			fmt.Sprintf("${%s}", RegexFps1.SubexpNames()[2]))
-		parts := strings.Split(matched, " ")
-		f, _ := strconv.ParseFloat(parts[0], 32)
-		return float32(f), nil
+		return strings.Split(matched, " ")[0], nil
 	}
 
 	if fps2 {
 		matched := RegexFps2.ReplaceAllString(raw,
 			fmt.Sprintf("${%s}", RegexFps2.SubexpNames()[2]))
-		parts := strings.Split(matched, " ")
-		f, _ := strconv.ParseFloat(parts[0], 32)
-		return float32(f), nil
+		return strings.Split(matched, " ")[0], nil
 	}
 
-	return empty, InvalidFps
+	return "", InvalidFps
 }
 
 func ParseDimension(raw string) (VideoSize, error) {
@@ -122,6 +152,9 @@ func ParseDuration(raw string) (time.Duration, error) {
 
 	raw = strings.TrimSpace(raw)
 
+	if RegexDurationNA.MatchString(raw) {
+		return 0, DurationNA
+	}
 	if !Regex1.MatchString(raw) {
 		return 0, InvalidDuration
 	}
@@ -141,9 +174,67 @@ func ParseDuration(raw string) (time.Duration, error) {
 	return retval, nil
 }
 
+// Timestamp of the first frame, from the Duration line
+func ParseStart(raw string) (time.Duration, error) {
+	m := RegexStart.FindStringSubmatch(raw)
+	if m == nil {
+		return 0, InvalidStart
+	}
+	secs, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, InvalidStart
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// The sample (pixel) & display aspect ratios. SAR 0:1 means
+// unknown & comes back as the zero Rational.
+func ParseAspect(raw string) (sar, dar util.Rational, err error) {
+	m := RegexAspect.FindStringSubmatch(raw)
+	if m == nil {
+		return sar, dar, InvalidAspect
+	}
+	return ratio(m[1]), ratio(m[2]), nil
+}
+
+func ratio(raw string) util.Rational {
+	parts := strings.SplitN(raw, ":", 2)
+	num, _ := strconv.ParseInt(parts[0], 10, 64)
+	den, _ := strconv.ParseInt(parts[1], 10, 64)
+	if r := (util.Rational{Num: num, Den: den}); r.Valid() {
+		return r
+	}
+	return util.Rational{}
+}
+
+func inverse(r util.Rational) util.Rational {
+	return util.Rational{Num: r.Den, Den: r.Num}
+}
+
+// Clockwise degrees, a multiple of 90, that the frames are
+// turned by when displayed. The display matrix, which newer
+// ffprobes print instead of the rotate tag, is counterclockwise.
+func ParseRotation(raw string) (int, error) {
+	var degrees float64
+	if m := RegexRotate.FindStringSubmatch(raw); m != nil {
+		degrees, _ = strconv.ParseFloat(m[1], 64)
+	} else if m := RegexDisplayMatrix.FindStringSubmatch(raw); m != nil {
+		degrees, _ = strconv.ParseFloat(m[1], 64)
+		degrees = -degrees
+	} else {
+		return 0, InvalidRotation
+	}
+	turns := math.Round(degrees / 90)
+	if turns*90 != math.Round(degrees) {
+		return 0, InvalidRotation
+	}
+	return (int(turns)%4 + 4) % 4 * 90, nil
+}
+
 // Parses output from ffprobe
 func parse(data *bytes.Buffer) (*VideoReader, error) {
-	vid := new(VideoReader)
+	// until a Duration line says otherwise
+	vid := &VideoReader{UnknownDuration: true}
 
 	p := pipe.Line(
 		pipe.Read(bytes.NewReader(data.Bytes())),
@@ -152,15 +243,22 @@ func parse(data *bytes.Buffer) (*VideoReader, error) {
 			s := string(line)
 			s = strings.TrimSpace(s)
 			return strings.HasPrefix(s, sDuration) ||
-				strings.Index(s, sVideo) >= 0
+				strings.Index(s, sVideo) >= 0 ||
+				strings.HasPrefix(s, sRotate) ||
+				strings.HasPrefix(s, sDisplayMatrix)
 		}),
 
 		Processor(func(line []byte) []byte {
 			s := chomp(line)
 			if strings.HasPrefix(s, sDuration) {
-				d, _ := ParseDuration(s)
-				// TODO log the err ??
+				d, err := ParseDuration(s)
+				if err != nil {
+					util.Log.Debug("duration is unknown", "line", s,
+						"error", err)
+				}
 				vid.Duration = d
+				vid.UnknownDuration = err != nil
+				vid.Start, _ = ParseStart(s)
 				return make([]byte, 0)
 			} else {
 				return line // leave untouched
@@ -172,16 +270,29 @@ func parse(data *bytes.Buffer) (*VideoReader, error) {
 			if strings.Index(s, sVideo) >= 0 {
 				dims, _ := ParseDimension(s)
 				fps, _ := ParseFps(s)
+				rate, _ := ParseFrameRate(s)
+				sar, dar, _ := ParseAspect(s)
 				// TODO log the err ??
 				vid.VideoSize = dims
 				vid.Fps = fps
+				vid.Rate = rate
+				vid.SampleAspect, vid.DisplayAspect = sar, dar
 				return make([]byte, 0)
 			} else {
 				return line // leave untouched
 			}
 		}),
 
-		CustomWrite(os.Stdout),
+		Processor(func(line []byte) []byte {
+			s := chomp(line)
+			if rotation, err := ParseRotation(s); err == nil {
+				vid.Rotation = rotation
+				return make([]byte, 0)
+			}
+			return line
+		}),
+
+		LogWrite("ffprobe"),
 		//pipe.Write(os.Stdout),
 	)
 	err := pipe.Run(p)
@@ -222,6 +333,17 @@ func Processor(f func(line []byte) []byte) pipe.Pipe {
 	})
 }
 
+// Logs what the processors left untouched at debug level
+func LogWrite(program string) pipe.Pipe {
+	return pipe.TaskFunc(func(s *pipe.State) error {
+		scanner := bufio.NewScanner(s.Stdin)
+		for scanner.Scan() {
+			util.Log.Debug(program, "line", scanner.Text())
+		}
+		return scanner.Err()
+	})
+}
+
 // A custom writer for debugging purposes
 func CustomWrite(w io.Writer) pipe.Pipe {
 	return pipe.TaskFunc(func(s *pipe.State) error {
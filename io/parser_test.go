@@ -7,6 +7,7 @@ import (
 	"time"
 	//. "io"
 	theio "github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -85,6 +86,16 @@ func TestFps(t *testing.T) {
 	}
 }
 
+func TestFrameRate(t *testing.T) {
+	r, err := theio.ParseFrameRate(streams[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "30000/1001", r.String())
+
+	r, err = theio.ParseFrameRate(streams[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "25", r.String())
+}
+
 func TestSizeRegex(t *testing.T) {
 	for k, v := range maps {
 		d, _ := theio.ParseDimension(k)
@@ -104,4 +115,54 @@ func TestDurationRegex(t *testing.T) {
 			t.Errorf("tsk tsk: Duration")
 		}
 	}
+
+	raw := "Duration: N/A, start: 1503.400000, bitrate: N/A"
+	_, err = theio.ParseDuration(raw)
+	assert.Equal(t, err, theio.DurationNA)
+	start, err := theio.ParseStart(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, 1503400*time.Millisecond, start)
+}
+
+func TestRotation(t *testing.T) {
+	for raw, want := range map[string]int{
+		"rotate          : 90":                       90,
+		"rotate          : -90":                      270,
+		"displaymatrix: rotation of -90.00 degrees":  90,
+		"displaymatrix: rotation of 90.00 degrees":   270,
+		"displaymatrix: rotation of 180.00 degrees":  180,
+		"displaymatrix: rotation of -0.00 degrees":   0,
+		"displaymatrix: rotation of -270.00 degrees": 270,
+		"displaymatrix: rotation of 360.00 degrees":  0,
+		"rotate          : 450":                      90,
+	} {
+		r, err := theio.ParseRotation(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, want, r, raw)
+	}
+	for _, raw := range []string{"rotate          : 45",
+		"displaymatrix: rotation of 12.50 degrees", streams[1]} {
+		_, err := theio.ParseRotation(raw)
+		assert.Equal(t, theio.InvalidRotation, err, raw)
+	}
+}
+
+func TestAspect(t *testing.T) {
+	sar, dar, err := theio.ParseAspect(streams[1])
+	assert.NoError(t, err)
+	assert.Equal(t, util.Rational{Num: 1, Den: 1}, sar)
+	assert.Equal(t, util.Rational{Num: 4, Den: 3}, dar)
+
+	sar, dar, err = theio.ParseAspect("Stream #0:0: Video: dvvideo, " +
+		"yuv411p, 720x480 [SAR 32:27 DAR 16:9], 28771 kb/s, 29.97 fps,")
+	assert.NoError(t, err)
+	assert.Equal(t, util.Rational{Num: 32, Den: 27}, sar)
+	assert.Equal(t, util.Rational{Num: 16, Den: 9}, dar)
+
+	sar, _, err = theio.ParseAspect("yuv420p, 640x360 [SAR 0:1 DAR 0:1], ")
+	assert.NoError(t, err)
+	assert.False(t, sar.Valid())
+
+	_, _, err = theio.ParseAspect(streams[0])
+	assert.Equal(t, theio.InvalidAspect, err)
 }
@@ -0,0 +1,288 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	stdio "io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/javouhey/seneca/util"
+	"launchpad.net/tomb"
+)
+
+var ErrCancelled = errors.New("cancelled")
+
+// How long a cancelled ffmpeg gets to exit before it is killed
+var TerminateTimeout = 5 * time.Second
+
+// Runs an ffmpeg tool to completion. Closing cancel terminates
+// it, escalating to a kill after TerminateTimeout.
+// The command line & stderr are appended to logfile if given.
+// A failure is returned as an ExecError (or a type embedding
+// it) that carries the tail of stderr.
+func execute(cmdFull []string, cancel <-chan struct{}, logfile string) error {
+	return executeTo(cmdFull, cancel, logfile, nil)
+}
+
+// Like execute, with stderr also copied to out if given
+func executeTo(cmdFull []string, cancel <-chan struct{}, logfile string,
+	out stdio.Writer) error {
+
+	tail := NewTail(TAIL_SIZE)
+	var stderr stdio.Writer = tail
+	if out != nil {
+		stderr = stdio.MultiWriter(tail, out)
+	}
+	if !util.IsEmpty(logfile) {
+		log, err := os.OpenFile(logfile,
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer log.Close()
+		fmt.Fprintf(log, "%s\n", cmdFull)
+		stderr = stdio.MultiWriter(log, stderr)
+	}
+
+	proc, err := currentRunner().Start(cmdFull, stderr)
+	if err != nil {
+		util.Log.Error("failed executing", "program", cmdFull[0], "error", err)
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- proc.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			err = classify(cmdFull, tail.String(), err)
+			util.Log.Error("executed with errors", "error", err)
+			logStderr(cmdFull, tail.String())
+		}
+		return err
+	case <-cancel:
+		proc.Terminate()
+		select {
+		case <-done:
+		case <-time.After(TerminateTimeout):
+			util.Log.Warn("killing unresponsive process", "program", cmdFull[0])
+			proc.Kill()
+			<-done
+		}
+		return ErrCancelled
+	}
+}
+
+// With -vv the stderr of a failed program is logged line by line
+func logStderr(cmdFull []string, stderr string) {
+	if !util.Log.Enabled(util.DEBUG) {
+		return
+	}
+	util.Log.Debug("failed command", "argv", strings.Join(cmdFull, " "))
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		util.Log.Debug("stderr", "program", cmdFull[0],
+			"line", strings.TrimRight(line, "\r"))
+	}
+}
+
+// Wall time spent in one stage of a Pipeline
+type Stage struct {
+	Name    string
+	Elapsed time.Duration
+}
+
+// Chains the stages that turn a video into an animated GIF
+// (or a contact sheet with -sheet). -direct skips the mux stage.
+type Pipeline struct {
+	Tombstone tomb.Tomb
+
+	mu     sync.Mutex
+	stages []Stage
+}
+
+func (p *Pipeline) Run(vr *VideoReader, args *util.Arguments) {
+	go func() {
+		defer p.Tombstone.Done()
+		p.Tombstone.Kill(p.run(vr, args))
+	}()
+}
+
+// Kills the running stage & waits for the pipeline to wind down
+func (p *Pipeline) Stop() error {
+	p.Tombstone.Kill(ErrCancelled)
+	return p.Tombstone.Wait()
+}
+
+// Stages returns the stages that ran so far, in order
+func (p *Pipeline) Stages() []Stage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stages := make([]Stage, len(p.stages))
+	copy(stages, p.stages)
+	return stages
+}
+
+func (p *Pipeline) timed(name string, stage func() error) error {
+	start := time.Now()
+	err := stage()
+	p.mu.Lock()
+	p.stages = append(p.stages, Stage{name, time.Since(start)})
+	p.mu.Unlock()
+	return err
+}
+
+func (p *Pipeline) cancelled() bool {
+	select {
+	case <-p.Tombstone.Dying():
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Pipeline) run(vr *VideoReader, args *util.Arguments) error {
+	dying := p.Tombstone.Dying()
+
+	if vr.Workspace == nil && !args.DryRun {
+		ws, err := NewWorkspace(vr.Root)
+		if err != nil {
+			util.Log.Error("unable to create workspace", "error", err)
+			return err
+		}
+		vr.Workspace = ws
+	}
+
+	if err := vr.ResolveWindow(args); err != nil {
+		return err
+	}
+
+	if args.AutoClip {
+		err := p.timed("auto-clip", func() error {
+			return AutoClip(vr, args, dying)
+		})
+		if err != nil {
+			return err
+		}
+		// -from moved, check the window again
+		if err := vr.CheckWindow(args); err != nil {
+			return err
+		}
+	}
+
+	if args.Sheet {
+		return p.timed("sheet", func() error {
+			return <-ContactSheet{Cancel: dying}.Run(vr, args)
+		})
+	}
+
+	if args.Stream {
+		return p.stream(vr, args)
+	}
+
+	err := p.timed("frames", func() error {
+		return <-FrameGenerator{Cancel: dying}.Run(vr, args)
+	})
+	if err != nil {
+		return err
+	}
+
+	if args.SmoothLoop > 0 && !p.cancelled() {
+		err := p.timed("loop", func() error {
+			return <-new(LoopSmoother).Run(vr, args)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.AutoLoop > 0 && !p.cancelled() {
+		err := p.timed("auto-loop", func() error {
+			return <-new(LoopFinder).Run(vr, args)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Dedup && !p.cancelled() {
+		err := p.timed("dedup", func() error {
+			return <-new(Deduplicator).Run(vr, args)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.cancelled() {
+		return ErrCancelled
+	}
+	if !args.Direct {
+		err = p.timed("mux", func() error {
+			muxer := &Muxer{Cancel: dying}
+			muxer.Run(vr, args).Wait()
+			return muxer.Error()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.cancelled() {
+		return ErrCancelled
+	}
+	return p.gif(vr, args)
+}
+
+// frames | gif with -direct, otherwise frames | mux then gif
+func (p *Pipeline) stream(vr *VideoReader, args *util.Arguments) error {
+	name := "frames|mux"
+	if args.Direct {
+		name = "frames|gif"
+	}
+	err := p.timed(name, func() error {
+		return <-FrameGenerator{Cancel: p.Tombstone.Dying()}.Stream(vr, args)
+	})
+	if err != nil || args.Direct {
+		return err
+	}
+	if p.cancelled() {
+		return ErrCancelled
+	}
+	return p.gif(vr, args)
+}
+
+func (p *Pipeline) gif(vr *VideoReader, args *util.Arguments) error {
+	dying := p.Tombstone.Dying()
+	return p.timed("gif", func() error {
+		gif := new(GifWriter)
+		gif.Run(vr, args)
+		select {
+		case <-dying:
+			gif.Stop()
+			return ErrCancelled
+		case <-gif.Tombstone.Dead():
+			return gif.Tombstone.Err()
+		}
+	})
+}
@@ -0,0 +1,489 @@
+package io_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	theio "github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/io/fake"
+	"github.com/javouhey/seneca/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupFake(t *testing.T) (*fake.Runner, string) {
+	r := fake.NewRunner()
+	r.On("ffprobe").Stderr = fake.Probe(60*time.Second, 640, 480, 25)
+	theio.SetRunner(r)
+	assert.NoError(t, theio.Configure(theio.Programs{}))
+
+	tmp, err := ioutil.TempDir("", "seneca-pipeline")
+	assert.NoError(t, err)
+	return r, tmp
+}
+
+func newVideo(t *testing.T, tmp string, options ...string) (*theio.VideoReader,
+	*util.Arguments) {
+
+	args := util.NewArguments()
+	assert.NoError(t, args.Parse(options))
+	vr, err := theio.NewVideoReader("/videos/plane.mp4", false)
+	assert.NoError(t, err)
+	vr.Root = tmp
+	return vr, args
+}
+
+func TestPipelineWithFakeRunner(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	vr, args := newVideo(t, tmp, "-fps", "10", "-length", "2s")
+	assert.Equal(t, 60*time.Second, vr.Duration)
+	assert.Equal(t, theio.VideoSize{640, 480}, vr.VideoSize)
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	calls := r.Calls()
+	// ffprobe & ffmpeg -h by Configure, then probe + 3 stages
+	if assert.Equal(t, 6, len(calls)) {
+		assert.Equal(t, "/videos/plane.mp4", calls[2][1])
+		assert.Contains(t, calls[4], "libx264")
+		assert.Equal(t, vr.Result(), calls[5][len(calls[5])-1])
+	}
+	frames, _ := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	assert.Equal(t, 3, len(frames))
+	_, err := os.Stat(vr.Result())
+	assert.NoError(t, err)
+}
+
+func TestPipelineDirect(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	vr, args := newVideo(t, tmp, "-fps", "10", "-direct")
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	// no libx264 between the frames & the GIF
+	calls := r.Calls()
+	if assert.Equal(t, 5, len(calls)) {
+		assert.Equal(t, []string{"ffmpeg", "-f", "image2", "-framerate", "10",
+			"-i", filepath.Join(vr.PngDir, vr.TmpFile)}, calls[4][:7])
+	}
+	var names []string
+	for _, stage := range p.Stages() {
+		names = append(names, stage.Name)
+	}
+	assert.Equal(t, []string{"frames", "gif"}, names)
+}
+
+func TestPipelineStream(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	vr, args := newVideo(t, tmp, "-stream", "-direct")
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	calls := r.Calls()
+	if assert.Equal(t, 5, len(calls)) {
+		producer, consumer := calls[4], calls[3]
+		assert.Equal(t, "pipe:1", producer[len(producer)-1])
+		assert.Contains(t, consumer, "pipe:0")
+		assert.Equal(t, vr.Result(), consumer[len(consumer)-1])
+	}
+	_, err := os.Stat(vr.PngDir)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "frames|gif", p.Stages()[0].Name)
+}
+
+func TestPipelineStreamFailures(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	// the consumer fails: its error is the one reported
+	consumer := r.On("pipe:0")
+	consumer.Stderr = "Unknown encoder 'libx264'\n"
+	consumer.Fail = 1
+	vr, args := newVideo(t, tmp, "-stream")
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	var unknown *theio.UnknownEncoderError
+	assert.True(t, errors.As(p.Tombstone.Wait(), &unknown))
+
+	// the producer fails: the consumer is stopped
+	r.On("pipe:0").Delay = time.Minute
+	producer := r.On("image2pipe -c:v ppm")
+	producer.Frames, producer.Fail = 0, 1
+	vr, args = newVideo(t, tmp, "-stream")
+	p = new(theio.Pipeline)
+	p.Run(vr, args)
+	start := time.Now()
+	var exec *theio.ExecError
+	if assert.True(t, errors.As(p.Tombstone.Wait(), &exec)) {
+		assert.Contains(t, exec.Cmd, "pipe:1")
+	}
+	assert.True(t, time.Since(start) < time.Second)
+
+	// cancelling stops both ends
+	r.On("image2pipe -c:v ppm").Delay = time.Minute
+	vr, args = newVideo(t, tmp, "-stream")
+	p = new(theio.Pipeline)
+	p.Run(vr, args)
+	time.Sleep(50 * time.Millisecond)
+	start = time.Now()
+	assert.Equal(t, theio.ErrCancelled, p.Stop())
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestPipelineStageFailure(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("libx264").Fail = 1
+	vr, args := newVideo(t, tmp)
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	err := p.Tombstone.Wait()
+	var exit *fake.ExitError
+	if assert.True(t, errors.As(err, &exit)) {
+		assert.Equal(t, 1, exit.Code)
+	}
+	// GifWriter never ran
+	assert.Equal(t, 5, len(r.Calls()))
+}
+
+func TestPipelineRotated(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("ffprobe").Stderr = fake.Probe(60*time.Second, 640, 480, 25) +
+		"    Side data:\n      displaymatrix: rotation of -90.00 degrees\n"
+	vr, args := newVideo(t, tmp, "-scale", "240:_", "-flip", "h")
+	assert.Equal(t, 90, vr.Rotation)
+	assert.Equal(t, theio.VideoSize{640, 480}, vr.VideoSize)
+	size, _, dar := vr.Oriented(args)
+	assert.Equal(t, theio.VideoSize{480, 640}, size)
+	assert.Equal(t, util.Rational{Num: 3, Den: 4}, dar)
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	frames := strings.Join(r.Calls()[3], " ")
+	assert.Contains(t, frames, "-noautorotate -i /videos/plane.mp4")
+	assert.Contains(t, frames, "-vf transpose=clock,hflip,"+args.ScaleFilter)
+}
+
+// The frames stay as stored & so does their size
+func TestPipelineNoAutorotate(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("ffprobe").Stderr = fake.Probe(60*time.Second, 640, 480, 25) +
+		"    Side data:\n      displaymatrix: rotation of -90.00 degrees\n"
+	vr, args := newVideo(t, tmp, "-no-autorotate")
+	size, _, dar := vr.Oriented(args)
+	assert.Equal(t, theio.VideoSize{640, 480}, size)
+	assert.Equal(t, util.Rational{Num: 4, Den: 3}, dar)
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	frames := strings.Join(r.Calls()[3], " ")
+	assert.Contains(t, frames, "-noautorotate -i /videos/plane.mp4")
+	assert.NotContains(t, frames, "transpose")
+
+	// -rotate still turns them
+	args = util.NewArguments()
+	assert.NoError(t, args.Parse([]string{"-no-autorotate", "-rotate", "270"}))
+	size, _, _ = vr.Oriented(args)
+	assert.Equal(t, theio.VideoSize{480, 640}, size)
+}
+
+func TestPipelineClassifiedFailure(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	rule := r.On("libx264")
+	rule.Stderr = "ffmpeg version 2.2\n[NULL @ 0x1] Unknown encoder 'libx264'\n"
+	rule.Fail = 1
+	vr, args := newVideo(t, tmp)
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	err := p.Tombstone.Wait()
+	var unknown *theio.UnknownEncoderError
+	if assert.True(t, errors.As(err, &unknown)) {
+		assert.Equal(t, "[NULL @ 0x1] Unknown encoder 'libx264'", unknown.Line)
+		assert.Contains(t, unknown.Cmd, "libx264")
+		assert.Contains(t, unknown.Stderr, "ffmpeg version 2.2")
+		assert.Contains(t, err.Error(), "built without an encoder")
+	}
+}
+
+// showinfo & metadata lines for every frame of 25 fps, all of
+// them still but for a burst of activity from busy on
+func showinfo(secs, busy int) string {
+	var b strings.Builder
+	for n := 0; n < secs*25; n++ {
+		score := 0.0
+		if n >= busy*25 && n < (busy+2)*25 {
+			score = 0.5
+		}
+		fmt.Fprintf(&b, "[Parsed_showinfo_1 @ 0x1] n:%d pts:%d pts_time:%g\n"+
+			"[Parsed_metadata_2 @ 0x2] lavfi.scene_score=%f\n",
+			n, n*512, float64(n)/25, score)
+	}
+	return b.String()
+}
+
+func TestPipelineAutoClip(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	// larger than a Tail, parsed as it is printed
+	r.On("showinfo").Stderr = showinfo(60, 41)
+	vr, args := newVideo(t, tmp, "-auto-clip", "-length", "2s")
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+	assert.Equal(t, "00:00:41", args.From.String())
+}
+
+// The frames faded in by -smooth-loop must be in the video too
+func TestPipelineAutoClipOverscan(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("showinfo").Stderr = showinfo(60, 58)
+	vr, args := newVideo(t, tmp, "-auto-clip", "-length", "2s",
+		"-smooth-loop", "1s")
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+	assert.Equal(t, "00:00:57", args.From.String())
+}
+
+func TestPipelineDryRunAutoClip(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	vr, args := newVideo(t, tmp, "-auto-clip", "-dry-run")
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+	// Configure & the probe, nothing else is run
+	assert.Equal(t, 3, len(r.Calls()))
+}
+
+func TestPipelineCancel(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("image2 -vsync cfr").Delay = time.Minute
+	vr, args := newVideo(t, tmp)
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, theio.ErrCancelled, p.Stop())
+	assert.Equal(t, 4, len(r.Calls()))
+}
+
+func TestPipelineCancelAutoClip(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("showinfo").Delay = time.Minute
+	vr, args := newVideo(t, tmp, "-auto-clip")
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	assert.Equal(t, theio.ErrCancelled, p.Stop())
+	assert.True(t, time.Since(start) < time.Second)
+	// the scene detection was the last thing started
+	calls := r.Calls()
+	if assert.Equal(t, 4, len(calls)) {
+		assert.Contains(t, strings.Join(calls[3], " "), "showinfo")
+	}
+}
+
+func TestPipelineSegments(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("image2 -vsync cfr").Frames = 1000
+	vr, args := newVideo(t, tmp, "-fps", "10", "-length", "21s",
+		"-from", "00:00:10", "-parallel", "8")
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	// 21s only makes room for 4 segments of 53, 53, 52 & 52 frames
+	var segments [][]string
+	for _, call := range r.Calls() {
+		for _, arg := range call {
+			if arg == "-start_number" {
+				segments = append(segments, call)
+			}
+		}
+	}
+	assert.Equal(t, 4, len(segments))
+	frames, _ := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	assert.Equal(t, 210, len(frames))
+	for i := 1; i <= 210; i++ {
+		_, err := os.Stat(filepath.Join(vr.PngDir, fmt.Sprintf(vr.TmpFile, i)))
+		assert.NoError(t, err)
+	}
+}
+
+func TestPipelineLongSegments(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("image2 -vsync cfr").Frames = 1000
+	vr, args := newVideo(t, tmp, "-fps", "30", "-length", "60s",
+		"-parallel", "4")
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	// the segments run concurrently, in any order
+	var starts []int
+	for _, call := range r.Calls() {
+		for i, arg := range call {
+			if arg == "-start_number" {
+				n, err := strconv.Atoi(call[i+1])
+				assert.NoError(t, err)
+				starts = append(starts, n)
+			}
+		}
+	}
+	sort.Ints(starts)
+	assert.Equal(t, []int{1, 451, 901, 1351}, starts)
+	frames, _ := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	assert.Equal(t, 1800, len(frames))
+}
+
+func TestSegments(t *testing.T) {
+	segs := theio.Segments(21*time.Second, 10, 8)
+	assert.Equal(t, []theio.Segment{{1, 53}, {54, 53}, {107, 52}, {159, 52}},
+		segs)
+	assert.Equal(t, 5300*time.Millisecond, segs[1].Offset(10))
+
+	assert.Equal(t, []theio.Segment{{1, 30}},
+		theio.Segments(3*time.Second, 10, 8))
+	assert.Equal(t, 2, len(theio.Segments(59*time.Second, 30, 2)))
+}
+
+func TestPipelineDedup(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	r.On("image2 -vsync cfr").Frames = 4
+	vr, args := newVideo(t, tmp, "-dedup", "-dedup-tolerance", "20")
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	frames, _ := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	assert.Equal(t, 2, len(frames))
+	calls := r.Calls()
+	assert.Contains(t, calls[4], filepath.Join(vr.TmpDir, theio.CONCAT))
+}
+
+func TestPipelineSmoothLoop(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	// 2s at 2 fps, plus 1s to fade into the start
+	r.On("image2 -vsync cfr").Frames = 6
+	vr, args := newVideo(t, tmp, "-fps", "2", "-length", "2s",
+		"-smooth-loop", "1s")
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	assert.Contains(t, strings.Join(r.Calls()[3], " "), "-t 3 ")
+	frames, _ := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	assert.Equal(t, 4, len(frames))
+}
+
+func TestPipelineAutoLoop(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	// shades 40, 80 .. 240, then 24 is the closest to the first
+	r.On("image2 -vsync cfr").Frames = 8
+	vr, args := newVideo(t, tmp, "-fps", "2", "-length", "3s",
+		"-auto-loop", "1s")
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	assert.NoError(t, p.Tombstone.Wait())
+
+	assert.Contains(t, strings.Join(r.Calls()[3], " "), "-t 4 ")
+	frames, _ := filepath.Glob(filepath.Join(vr.PngDir, "*.png"))
+	assert.Equal(t, 6, len(frames))
+	assert.Equal(t, theio.LoopPoint{0, 6, 16.0 * 100 / 255}, *vr.Loop)
+	assert.Equal(t, 3*time.Second, args.Length)
+}
+
+func TestPipelineKillsUnresponsive(t *testing.T) {
+	r, tmp := setupFake(t)
+	defer theio.SetRunner(nil)
+	defer os.RemoveAll(tmp)
+
+	timeout := theio.TerminateTimeout
+	theio.TerminateTimeout = 50 * time.Millisecond
+	defer func() { theio.TerminateTimeout = timeout }()
+
+	rule := r.On("image2 -vsync cfr")
+	rule.Delay, rule.IgnoreTerm = time.Minute, true
+	vr, args := newVideo(t, tmp)
+
+	p := new(theio.Pipeline)
+	p.Run(vr, args)
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	assert.Equal(t, theio.ErrCancelled, p.Stop())
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	stdio "io"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// Locates & starts ffmpeg and ffprobe. Every process of this
+// package goes through the Runner installed with SetRunner.
+type Runner interface {
+	LookPath(file string) (string, error)
+
+	// argv[0] is the program. stderr may be nil.
+	Start(argv []string, stderr stdio.Writer) (Process, error)
+
+	// Like Start, also connecting stdin & stdout (either may be
+	// nil) for programs that read or write pipe:0 & pipe:1
+	StartPiped(argv []string, stdin stdio.Reader,
+		stdout, stderr stdio.Writer) (Process, error)
+}
+
+type Process interface {
+	Wait() error
+
+	// Asks the process to exit (SIGTERM where supported)
+	Terminate() error
+	Kill() error
+}
+
+var (
+	runnerMu sync.Mutex
+	runner   Runner = ExecRunner{}
+)
+
+// SetRunner replaces the Runner; nil restores ExecRunner
+func SetRunner(r Runner) {
+	runnerMu.Lock()
+	defer runnerMu.Unlock()
+	if r == nil {
+		r = ExecRunner{}
+	}
+	runner = r
+}
+
+func currentRunner() Runner {
+	runnerMu.Lock()
+	defer runnerMu.Unlock()
+	return runner
+}
+
+// Runs real programs with os/exec
+type ExecRunner struct{}
+
+func (ExecRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (r ExecRunner) Start(argv []string, stderr stdio.Writer) (Process, error) {
+	return r.StartPiped(argv, nil, nil, stderr)
+}
+
+func (ExecRunner) StartPiped(argv []string, stdin stdio.Reader,
+	stdout, stderr stdio.Writer) (Process, error) {
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return execProcess{cmd}, nil
+}
+
+type execProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p execProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+// Falls back to Kill where signals cannot be sent, e.g. Windows
+func (p execProcess) Terminate() error {
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return p.cmd.Process.Kill()
+	}
+	return nil
+}
+
+func (p execProcess) Kill() error {
+	return p.cmd.Process.Kill()
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"bufio"
+	"fmt"
+	stdio "io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/javouhey/seneca/util"
+)
+
+const (
+	// Every frame that differs from its predecessor counts
+	// towards the activity of a window.
+	AUTOCLIP_THRESHOLD = 0.0
+
+	sShowinfo   = "Parsed_showinfo"
+	sSceneScore = "lavfi.scene_score="
+)
+
+var (
+	// [Parsed_showinfo_1 @ 0x1f4e0c0] n:   0 pts:  61440 pts_time:4.8  ..
+	RegexPtsTime = regexp.MustCompile(`pts_time:\s*(?P<secs>\d+(\.\d+)?)`)
+)
+
+// A frame selected by ffmpeg's scene filter
+type Scene struct {
+	Time  time.Duration
+	Score float64
+}
+
+func (s Scene) String() string {
+	return fmt.Sprintf("%s  %0.4f", util.NewTimeCode(s.Time), s.Score)
+}
+
+func (f FrameGenerator) sceneCli(filename string, threshold float64) []string {
+	cmdFull := []string{ffmpegExec, "-hide_banner", "-nostats"}
+	cmdFull = append(cmdFull, "-i", filename, "-an")
+	cmdFull = append(cmdFull, "-vf",
+		fmt.Sprintf("select='gt(scene,%g)',showinfo,metadata=print",
+			threshold))
+	cmdFull = append(cmdFull, "-f", "null", "-")
+	return cmdFull
+}
+
+// DetectScenes lists the frames whose scene score exceeds threshold.
+// With dryRun the command is only printed to out (stdout when
+// nil) & there are no scenes. Closing cancel stops ffmpeg, see
+// execute.
+func DetectScenes(filename string, threshold float64, dryRun bool,
+	out stdio.Writer, cancel <-chan struct{}) ([]Scene, error) {
+
+	var f FrameGenerator
+	cmdFull := f.sceneCli(filename, threshold)
+	if dryRun {
+		fmt.Fprintf(stdout(out), "  %s\n", cmdFull)
+		return nil, nil
+	}
+
+	// showinfo prints a line per frame, so stderr is parsed as
+	// it comes & only its tail kept for classify
+	pr, pw := stdio.Pipe()
+	parsed := make(chan []Scene, 1)
+	failed := make(chan error, 1)
+	go func() {
+		scenes, err := parseScenes(pr)
+		// ffmpeg must never block on a parser that gave up
+		stdio.Copy(ioutil.Discard, pr)
+		parsed <- scenes
+		failed <- err
+	}()
+
+	err := executeTo(cmdFull, cancel, "", pw)
+	pw.Close()
+	scenes, perr := <-parsed, <-failed
+	if err != nil {
+		return nil, err
+	}
+	return scenes, perr
+}
+
+// Pairs the pts_time of showinfo with the score printed by
+// the metadata filter that follows it in the filtergraph.
+func parseScenes(r stdio.Reader) ([]Scene, error) {
+	scenes := make([]Scene, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, sShowinfo):
+			m := RegexPtsTime.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			secs, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				continue
+			}
+			scenes = append(scenes, Scene{
+				Time: time.Duration(secs * float64(time.Second))})
+
+		case strings.Contains(line, sSceneScore):
+			if len(scenes) == 0 {
+				continue
+			}
+			i := strings.Index(line, sSceneScore) + len(sSceneScore)
+			score, err := strconv.ParseFloat(strings.TrimSpace(line[i:]), 64)
+			if err != nil {
+				continue
+			}
+			scenes[len(scenes)-1].Score = score
+		}
+	}
+	return scenes, scanner.Err()
+}
+
+// MostActiveWindow returns the start of the window of the given
+// length whose scene scores add up to the largest total. A window
+// never extends past total, unless total is unknown (zero).
+func MostActiveWindow(scenes []Scene, length,
+	total time.Duration) time.Duration {
+
+	if length <= 0 || len(scenes) == 0 {
+		return 0
+	}
+
+	sorted := make([]Scene, len(scenes))
+	copy(sorted, scenes)
+	sort.Sort(byTime(sorted))
+
+	sums := make([]float64, len(sorted)+1)
+	for i, s := range sorted {
+		sums[i+1] = sums[i] + s.Score
+	}
+
+	activity := func(start time.Duration) float64 {
+		lo := sort.Search(len(sorted), func(i int) bool {
+			return sorted[i].Time >= start
+		})
+		hi := sort.Search(len(sorted), func(i int) bool {
+			return sorted[i].Time >= start+length
+		})
+		return sums[hi] - sums[lo]
+	}
+
+	var best time.Duration
+	bestScore := -1.0
+	for _, s := range sorted {
+		start := s.Time
+		if total > 0 && start+length > total {
+			start = total - length
+			if start < 0 {
+				start = 0
+			}
+		}
+		if score := activity(start); score > bestScore {
+			best, bestScore = start, score
+		}
+	}
+	return best
+}
+
+type byTime []Scene
+
+func (b byTime) Len() int           { return len(b) }
+func (b byTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byTime) Less(i, j int) bool { return b[i].Time < b[j].Time }
+
+// AutoClip replaces -from with the start of the busiest -length window
+func AutoClip(vr *VideoReader, args *util.Arguments,
+	cancel <-chan struct{}) error {
+
+	scenes, err := DetectScenes(vr.Filename, AUTOCLIP_THRESHOLD, args.DryRun,
+		vr.Out, cancel)
+	if err == ErrCancelled {
+		return err
+	}
+	if err != nil {
+		util.Log.Error("scene detection failed", "file", vr.Filename,
+			"error", err)
+		return err
+	}
+	if args.DryRun {
+		return nil
+	}
+
+	total := vr.Duration
+	if vr.UnknownDuration {
+		total = 0
+	}
+	// the Overscan past -length must fit before the end too
+	start := MostActiveWindow(scenes, args.Length+args.Overscan(), total)
+	args.From = util.NewTimeCode(start)
+	util.Log.Info("auto-clip picked a window", "from", args.From,
+		"length", args.Length)
+	return nil
+}
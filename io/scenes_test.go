@@ -0,0 +1,53 @@
+package io
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var showinfoFixture = `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'plane.mp4':
+[Parsed_showinfo_1 @ 0x1f4e0c0] n:   0 pts:  61440 pts_time:4.8     pos:  1234 fmt:yuv420p
+[Parsed_metadata_2 @ 0x1f4e1a0] frame:0    pts:61440   pts_time:4.8
+[Parsed_metadata_2 @ 0x1f4e1a0] lavfi.scene_score=0.523412
+[Parsed_showinfo_1 @ 0x1f4e0c0] n:   1 pts: 153600 pts_time:12.025  pos:  5678 fmt:yuv420p
+[Parsed_metadata_2 @ 0x1f4e1a0] frame:1    pts:153600  pts_time:12.025
+[Parsed_metadata_2 @ 0x1f4e1a0] lavfi.scene_score=0.310000
+`
+
+func TestParseScenes(t *testing.T) {
+	scenes, err := parseScenes(strings.NewReader(showinfoFixture))
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(scenes)) {
+		assert.Equal(t, 4800*time.Millisecond, scenes[0].Time)
+		assert.Equal(t, 0.523412, scenes[0].Score)
+		assert.Equal(t, 12025*time.Millisecond, scenes[1].Time)
+		assert.Equal(t, 0.31, scenes[1].Score)
+		assert.Equal(t, "00:00:12.025  0.3100", scenes[1].String())
+	}
+}
+
+func TestMostActiveWindow(t *testing.T) {
+	secs := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second))
+	}
+	scenes := []Scene{
+		{secs(40), 0.2},
+		{secs(1), 0.1},
+		{secs(10), 0.5},
+		{secs(11), 0.5},
+		{secs(12.5), 0.4},
+		{secs(20), 0.9},
+	}
+
+	assert.Equal(t, time.Duration(0), MostActiveWindow(nil, secs(3), 0))
+	assert.Equal(t, time.Duration(0), MostActiveWindow(scenes, 0, 0))
+
+	assert.Equal(t, secs(10), MostActiveWindow(scenes, secs(3), secs(60)))
+	assert.Equal(t, secs(20), MostActiveWindow(scenes, secs(1), secs(60)))
+
+	// window is pulled back so it ends with the video
+	assert.Equal(t, secs(39), MostActiveWindow(scenes[:1], secs(3), secs(42)))
+}
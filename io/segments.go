@@ -0,0 +1,145 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/javouhey/seneca/util"
+)
+
+// Shorter segments aren't worth starting another ffmpeg
+const SEGMENT_MIN = 5 * time.Second
+
+// A run of the numbered images, extracted by its own ffmpeg
+type Segment struct {
+	First  int // number of its first image, counting from 1
+	Frames int
+}
+
+// Offset of the segment from the start of the window
+func (s Segment) Offset(fps int) time.Duration {
+	return time.Duration(s.First-1) * time.Second / time.Duration(fps)
+}
+
+// Splits the frames of a window sampled at fps into at most n
+// segments of whole frames, so that the boundaries fall on the
+// same frames as a single ffmpeg would sample.
+func Segments(window time.Duration, fps, n int) []Segment {
+	total := int(math.Round(window.Seconds() * float64(fps)))
+	if max := int(window / SEGMENT_MIN); n > max {
+		n = max
+	}
+	if n > total {
+		n = total
+	}
+	if n < 1 {
+		return []Segment{{1, total}}
+	}
+	segs := make([]Segment, 0, n)
+	first := 1
+	for i := 0; i < n; i++ {
+		count := total / n
+		if i < total%n {
+			count++
+		}
+		segs = append(segs, Segment{first, count})
+		first += count
+	}
+	return segs
+}
+
+// Whether the frames can be extracted in segments: -parallel
+// is asked for & its frames map 1:1 onto the input. -speed
+// changes how many frames a second of input makes, -from now
+// has no fixed start & -stream has a single pipe.
+func segmentable(args *util.Arguments) bool {
+	return args.Parallel > 1 && !args.Stream && !args.FromNow &&
+		util.IsEmpty(args.SpeedSpec) && args.Length > 0
+}
+
+// The segments of this run; a single one unless the window is
+// long enough & segmentable.
+func (f FrameGenerator) segments(vr *VideoReader, args *util.Arguments) []Segment {
+	if !segmentable(args) {
+		return []Segment{{1, 0}}
+	}
+	return Segments(args.Length+args.Overscan(), args.Fps, args.Parallel)
+}
+
+// Like prepCli but for one segment. A frame more than needed is
+// read so that the last image is never short of input.
+func (f FrameGenerator) segmentCli(vr *VideoReader, args *util.Arguments,
+	seg Segment) []string {
+
+	start := args.From.Offset() + seg.Offset(args.Fps)
+	read := time.Duration(seg.Frames+1) * time.Second / time.Duration(args.Fps)
+
+	cmdFull := []string{ffmpegExec, "-ss", seconds(start), "-t", seconds(read)}
+	cmdFull = append(cmdFull, vr.noAutorotate()...)
+	cmdFull = append(cmdFull, "-i", vr.Filename)
+	cmdFull = append(cmdFull, watermarkInput(args)...)
+	cmdFull = append(cmdFull, "-an")
+	cmdFull = append(cmdFull, f.filters(vr, args)...)
+	cmdFull = append(cmdFull, "-q:v", "2", "-f", "image2", "-vsync", "cfr")
+	cmdFull = append(cmdFull, "-r", fmt.Sprintf("%d", args.Fps))
+	cmdFull = append(cmdFull, "-frames:v", fmt.Sprintf("%d", seg.Frames))
+	cmdFull = append(cmdFull, "-start_number", fmt.Sprintf("%d", seg.First))
+	cmdFull = append(cmdFull, "-y", "-progress", args.ProgressUrl())
+	cmdFull = append(cmdFull, filepath.Join(vr.PngDir, vr.TmpFile))
+	return cmdFull
+}
+
+// Runs the segments at the same time. The first failure stops
+// the others & is the one reported.
+func (f FrameGenerator) runSegments(vr *VideoReader, cmds [][]string) error {
+	stop := make(chan struct{})
+	var once sync.Once
+	halt := func() { once.Do(func() { close(stop) }) }
+	defer halt()
+	go func() {
+		select {
+		case <-f.Cancel:
+			halt()
+		case <-stop:
+		}
+	}()
+
+	errs := make(chan error, len(cmds))
+	for _, cmd := range cmds {
+		go func(cmd []string) {
+			err := execute(cmd, stop, vr.LogFile())
+			if err != nil {
+				halt()
+			}
+			errs <- err
+		}(cmd)
+	}
+
+	var first error
+	for range cmds {
+		if err := <-errs; err != nil && (first == nil || first == ErrCancelled) {
+			first = err
+		}
+	}
+	return first
+}
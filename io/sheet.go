@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/javouhey/seneca/util"
+)
+
+const (
+	// Width of a tile when -scale is not supplied
+	SHEET_TILE_WIDTH = 320
+)
+
+// Tiles frames sampled evenly across a window into one image
+type ContactSheet struct {
+	Cancel <-chan struct{}
+}
+
+func (c ContactSheet) Run(vr *VideoReader, args *util.Arguments) <-chan error {
+	cmdFull := c.prepCli(vr, args)
+	reply := make(chan error)
+	go func() {
+		if args.DryRun {
+			vr.printf("  %s\n", cmdFull)
+			reply <- nil
+			return
+		}
+
+		if err := os.MkdirAll(vr.TmpDir, os.ModePerm); err != nil {
+			util.Log.Error("unable to create directory", "dir", vr.TmpDir,
+				"error", err)
+			reply <- err
+			return
+		}
+
+		reply <- execute(cmdFull, c.Cancel, vr.LogFile())
+	}()
+	return reply
+}
+
+// Without an explicit -length the sheet spans from -from
+// to the end of the video, if that is known.
+func (c ContactSheet) window(vr *VideoReader, args *util.Arguments) time.Duration {
+	if args.IsSet("length") || args.FromNow {
+		return args.Length
+	}
+	if left, known := vr.Remaining(args.From.Offset()); known && left > 0 {
+		return left
+	}
+	return args.Length
+}
+
+func (c ContactSheet) filters(vr *VideoReader, args *util.Arguments) string {
+	tiles := args.SheetCols * args.SheetRows
+	secs := c.window(vr, args).Seconds()
+
+	vf := []string{fmt.Sprintf("fps=%d/%g", tiles, secs)}
+	vf = append(vf, orientFilters(vr, args)...)
+	if args.NeedScaling {
+		vf = append(vf, args.ScaleFilter)
+	} else {
+		scale, _ := util.WidthOnly.Decode(SHEET_TILE_WIDTH)
+		vf = append(vf, scale)
+	}
+	if args.SheetLabels {
+		vf = append(vf, fmt.Sprintf("drawtext=text='%%{pts\\:hms\\:%g}'"+
+			":x=4:y=h-th-4:fontcolor=white:box=1:boxcolor=black@0.5",
+			args.From.Offset().Seconds()))
+	}
+	vf = append(vf, fmt.Sprintf("tile=%dx%d", args.SheetCols, args.SheetRows))
+	return strings.Join(vf, ",")
+}
+
+func (c ContactSheet) prepCli(vr *VideoReader, args *util.Arguments) []string {
+	cmdFull := append([]string{ffmpegExec}, vr.seek(args)...)
+	cmdFull = append(cmdFull, "-t", fmt.Sprintf("%g",
+		c.window(vr, args).Seconds()))
+	cmdFull = append(cmdFull, vr.noAutorotate()...)
+	cmdFull = append(cmdFull, "-i", vr.Filename, "-an")
+	cmdFull = append(cmdFull, "-vf", c.filters(vr, args))
+	if args.SheetFormat != "png" {
+		cmdFull = append(cmdFull, "-q:v", "2")
+	}
+	cmdFull = append(cmdFull, "-frames:v", "1", "-y")
+	cmdFull = append(cmdFull, "-progress", args.ProgressUrl())
+
+	vr.Reset(0)
+	vr.Sheet = strings.TrimSuffix(vr.Gif, ".gif") + "-sheet." + args.SheetFormat
+	cmdFull = append(cmdFull, filepath.Join(vr.TmpDir, vr.Sheet))
+
+	vr.Work.log()
+	return cmdFull
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	stdio "io"
+	"os"
+	"time"
+
+	"github.com/javouhey/seneca/util"
+)
+
+// What the producer sees once its consumer is gone
+var errConsumerGone = errors.New("consumer of the frames exited")
+
+// One side of producer | consumer
+type pipeEnd struct {
+	argv    []string
+	tail    *Tail
+	proc    Process
+	err     error // valid once exited is closed
+	exited  chan struct{}
+	stopped bool // by the other side or a cancel
+}
+
+func startEnd(argv []string, stdin stdio.Reader, stdout stdio.Writer,
+	log *os.File) (*pipeEnd, error) {
+
+	e := &pipeEnd{argv: argv, tail: NewTail(TAIL_SIZE),
+		exited: make(chan struct{})}
+	var stderr stdio.Writer = e.tail
+	if log != nil {
+		fmt.Fprintf(log, "%s\n", argv)
+		stderr = stdio.MultiWriter(log, e.tail)
+	}
+	proc, err := currentRunner().StartPiped(argv, stdin, stdout, stderr)
+	if err != nil {
+		util.Log.Error("failed executing", "program", argv[0], "error", err)
+		return nil, err
+	}
+	e.proc = proc
+	go func() {
+		e.err = proc.Wait()
+		close(e.exited)
+	}()
+	return e, nil
+}
+
+// Terminates the process if it is running, escalating to a
+// kill after TerminateTimeout
+func (e *pipeEnd) stop() {
+	select {
+	case <-e.exited:
+		return
+	default:
+	}
+	e.stopped = true
+	e.proc.Terminate()
+	go func() {
+		select {
+		case <-e.exited:
+		case <-time.After(TerminateTimeout):
+			util.Log.Warn("killing unresponsive process", "program", e.argv[0])
+			e.proc.Kill()
+		}
+	}()
+}
+
+func (e *pipeEnd) failure() error {
+	err := classify(e.argv, e.tail.String(), e.err)
+	util.Log.Error("executed with errors", "error", err)
+	logStderr(e.argv, e.tail.String())
+	return err
+}
+
+// Runs producer | consumer. The pipe between them holds no
+// frames, so a slow consumer holds the producer back. When one
+// fails the other is stopped, & closing cancel stops both.
+func executePiped(producer, consumer []string, cancel <-chan struct{},
+	logfile string) error {
+
+	var log *os.File
+	if !util.IsEmpty(logfile) {
+		var err error
+		log, err = os.OpenFile(logfile,
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer log.Close()
+	}
+
+	pr, pw := stdio.Pipe()
+	cons, err := startEnd(consumer, pr, nil, log)
+	if err != nil {
+		return err
+	}
+	prod, err := startEnd(producer, nil, pw, log)
+	if err != nil {
+		pw.CloseWithError(err)
+		cons.stop()
+		<-cons.exited
+		return err
+	}
+
+	var failed error
+	prodExited, consExited := prod.exited, cons.exited
+	for prodExited != nil || consExited != nil {
+		select {
+		case <-prodExited:
+			prodExited = nil
+			// nil closes with EOF: the consumer finishes up
+			pw.CloseWithError(prod.err)
+			if prod.err != nil && !prod.stopped && failed == nil {
+				failed = prod.failure()
+				cons.stop()
+			}
+		case <-consExited:
+			consExited = nil
+			pr.CloseWithError(errConsumerGone)
+			if cons.err != nil && !cons.stopped && failed == nil {
+				failed = cons.failure()
+			}
+			// nothing reads its frames anymore
+			prod.stop()
+		case <-cancel:
+			cancel = nil
+			if failed == nil {
+				failed = ErrCancelled
+			}
+			prod.stop()
+			cons.stop()
+		}
+	}
+	return failed
+}
+
+// Streams the frames straight into the next stage, the GIF with
+// -direct or else temp.mp4, instead of through PNGs in PngDir.
+func (f FrameGenerator) Stream(vr *VideoReader, args *util.Arguments) <-chan error {
+	producer := f.prepCli(vr, args)
+	var consumer []string
+	if args.Direct {
+		consumer = new(GifWriter).prepCli(vr, args)
+	} else {
+		consumer = new(Muxer).prepCli(vr, args)
+	}
+	reply := make(chan error, 1)
+	go func() {
+		if args.DryRun {
+			printWatermark(vr, args)
+			vr.printf("  %s |\n  %s\n", producer, consumer)
+			reply <- nil
+			return
+		}
+		reply <- executePiped(producer, consumer, f.Cancel, vr.LogFile())
+	}()
+	return reply
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/javouhey/seneca/util"
+)
+
+// The watermark is the second input, looped so that it lasts
+// as long as the video
+func watermarkInput(args *util.Arguments) []string {
+	if util.IsEmpty(args.Watermark) {
+		return nil
+	}
+	return []string{"-loop", "1", "-i", args.Watermark}
+}
+
+// overlay's x:y for the corner of -watermark-position
+func watermarkXY(position string, margin int) string {
+	x, y := fmt.Sprint(margin), fmt.Sprint(margin)
+	if strings.HasSuffix(position, "right") {
+		x = fmt.Sprintf("W-w-%d", margin)
+	}
+	if strings.HasPrefix(position, "bottom") {
+		y = fmt.Sprintf("H-h-%d", margin)
+	}
+	return x + ":" + y
+}
+
+// vf, i.e. orientation, -scale & -speed, runs first so that the watermark is
+// sized against the width of the GIF, keeping its aspect ratio
+//
+//	[0:v]vf[main];[1:v][main]scale2ref=..[logo][ref];
+//	[logo]format=rgba,colorchannelmixer=..[faded];[ref][faded]overlay=..
+func watermarkGraph(vf string, args *util.Arguments) string {
+	var graph []string
+	main := "[0:v]"
+	if !util.IsEmpty(vf) {
+		graph = append(graph, main+vf+"[main]")
+		main = "[main]"
+	}
+	graph = append(graph,
+		fmt.Sprintf("[1:v]%sscale2ref=w=main_w*%g:h=ow/a[logo][ref]",
+			main, args.WatermarkScale),
+		fmt.Sprintf("[logo]format=rgba,colorchannelmixer=aa=%g[faded]",
+			args.WatermarkOpacity),
+		fmt.Sprintf("[ref][faded]overlay=%s:shortest=1",
+			watermarkXY(args.WatermarkPosition, args.WatermarkMargin)))
+	return strings.Join(graph, ";")
+}
+
+// -vf or, with a watermark, -filter_complex for FrameGenerator
+func (f FrameGenerator) filters(vr *VideoReader, args *util.Arguments) []string {
+	vf, s := f.combineVf(vr, args)
+	switch {
+	case !util.IsEmpty(args.Watermark):
+		return []string{"-filter_complex", watermarkGraph(s, args)}
+	case vf:
+		return []string{"-vf", s}
+	}
+	return nil
+}
+
+func printWatermark(vr *VideoReader, args *util.Arguments) {
+	if util.IsEmpty(args.Watermark) {
+		return
+	}
+	_, s := FrameGenerator{}.combineVf(vr, args)
+	vr.printf("  watermark filtergraph: %s\n", watermarkGraph(s, args))
+}
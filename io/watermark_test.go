@@ -0,0 +1,42 @@
+package io
+
+import (
+	"testing"
+
+	"github.com/javouhey/seneca/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatermarkXY(t *testing.T) {
+	assert.Equal(t, "8:8", watermarkXY("top-left", 8))
+	assert.Equal(t, "W-w-8:8", watermarkXY("top-right", 8))
+	assert.Equal(t, "0:H-h-0", watermarkXY("bottom-left", 0))
+	assert.Equal(t, "W-w-10:H-h-10", watermarkXY("bottom-right", 10))
+}
+
+func TestWatermarkFilters(t *testing.T) {
+	args := util.NewArguments()
+	assert.NoError(t, args.Parse([]string{"-scale", "200:_"}))
+	assert.Nil(t, watermarkInput(args))
+	assert.Equal(t, []string{"-vf", args.ScaleFilter},
+		FrameGenerator{}.filters(&VideoReader{}, args))
+
+	args.Watermark = "/logos/ours.png"
+	args.WatermarkScale, args.WatermarkOpacity = 0.2, 0.5
+	assert.Equal(t, []string{"-loop", "1", "-i", "/logos/ours.png"},
+		watermarkInput(args))
+	assert.Equal(t, []string{"-filter_complex",
+		"[0:v]" + args.ScaleFilter + "[main];" +
+			"[1:v][main]scale2ref=w=main_w*0.2:h=ow/a[logo][ref];" +
+			"[logo]format=rgba,colorchannelmixer=aa=0.5[faded];" +
+			"[ref][faded]overlay=W-w-10:H-h-10:shortest=1"},
+		FrameGenerator{}.filters(&VideoReader{}, args))
+
+	args = util.NewArguments()
+	assert.NoError(t, args.Parse(nil))
+	args.Watermark = "/logos/ours.png"
+	assert.Equal(t, "[1:v][0:v]scale2ref=w=main_w*0.15:h=ow/a[logo][ref];"+
+		"[logo]format=rgba,colorchannelmixer=aa=1[faded];"+
+		"[ref][faded]overlay=W-w-10:H-h-10:shortest=1",
+		watermarkGraph("", args))
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package io
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/javouhey/seneca/util"
+)
+
+const (
+	// Holds the pid of the run that owns a workspace
+	LOCKFILE = "seneca.pid"
+
+	// Abandoned workspaces younger than this are left alone
+	GC_AGE = 24 * time.Hour
+)
+
+// A directory of its own for every run, under <root>/seneca
+type Workspace struct {
+	Dir string
+}
+
+// NewWorkspace creates a uniquely named directory & locks it
+// with the pid of this process. root is os.TempDir() if empty.
+func NewWorkspace(root string) (*Workspace, error) {
+	parent := filepath.Join(workRoot(root), APPDIR)
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// the timestamp keeps the names sorted by age
+	dir, err := ioutil.TempDir(parent, fmt.Sprintf("%d-", time.Now().Unix()))
+	if err != nil {
+		return nil, err
+	}
+	lock := filepath.Join(dir, LOCKFILE)
+	if err = ioutil.WriteFile(lock, []byte(strconv.Itoa(os.Getpid())),
+		0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &Workspace{Dir: dir}, nil
+}
+
+// Unlike the time in seconds, no two runs get the same number
+func uniqueNum() int64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.BigEndian.Uint64(b[:]) >> 1)
+}
+
+func workRoot(root string) string {
+	if util.IsEmpty(root) {
+		return os.TempDir()
+	}
+	return root
+}
+
+// Unlock marks the run as finished. Sweep never removes an
+// unlocked workspace because it holds a result.
+func (w *Workspace) Unlock() error {
+	err := os.Remove(filepath.Join(w.Dir, LOCKFILE))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (w *Workspace) Remove() error {
+	return os.RemoveAll(w.Dir)
+}
+
+// Keep removes everything in the workspace but the given names
+func (w *Workspace) Keep(names ...string) error {
+	infos, err := ioutil.ReadDir(w.Dir)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, name := range names {
+		keep[name] = true
+	}
+	for _, fi := range infos {
+		if keep[fi.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(w.Dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cleanup removes the intermediate files of a run and keeps its
+// result & log. A failed run keeps nothing but its log, and its
+// lock so that Sweep removes it in time.
+func (v *VideoReader) Cleanup(failed bool) error {
+	if v.Workspace == nil {
+		if util.IsEmpty(v.PngDir) {
+			return nil
+		}
+		return os.RemoveAll(v.PngDir)
+	}
+	if failed {
+		if _, err := os.Stat(v.LogFile()); err != nil {
+			return v.Workspace.Remove()
+		}
+		return v.Workspace.Keep(v.Log, LOCKFILE)
+	}
+
+	var first error
+	remember := func(err error) {
+		if err != nil && !os.IsNotExist(err) && first == nil {
+			first = err
+		}
+	}
+	remember(os.RemoveAll(v.PngDir))
+	remember(os.Remove(filepath.Join(v.TmpDir, TMPMP4)))
+	if !util.IsEmpty(v.Concat) {
+		remember(os.Remove(filepath.Join(v.TmpDir, v.Concat)))
+	}
+	remember(v.Workspace.Unlock())
+	return first
+}
+
+// Sweep removes the workspaces under <root>/seneca that are
+// older than age & were abandoned, i.e. their owner is no longer
+// running or they still hold frames without a lock (as left by
+// older releases). With dryRun nothing is removed. It returns
+// the abandoned directories.
+func Sweep(root string, age time.Duration, dryRun bool) ([]string, error) {
+	parent := filepath.Join(workRoot(root), APPDIR)
+	infos, err := ioutil.ReadDir(parent)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	swept := make([]string, 0)
+	cutoff := time.Now().Add(-age)
+	for _, fi := range infos {
+		// server jobs & anything else that is not a workspace
+		if !fi.IsDir() || !startsWithDigit(fi.Name()) {
+			continue
+		}
+		dir := filepath.Join(parent, fi.Name())
+		if fi.ModTime().After(cutoff) || !abandoned(dir) {
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(dir); err != nil {
+				return swept, err
+			}
+		}
+		swept = append(swept, dir)
+	}
+	sort.Strings(swept)
+	return swept, nil
+}
+
+func startsWithDigit(name string) bool {
+	return len(name) > 0 && strings.IndexByte("0123456789", name[0]) >= 0
+}
+
+func abandoned(dir string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(dir, LOCKFILE))
+	if os.IsNotExist(err) {
+		_, err = os.Stat(filepath.Join(dir, PDIR))
+		return err == nil
+	}
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return true
+	}
+	return !alive(pid)
+}
+
+func alive(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// FindProcess only succeeds for live processes on Windows
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,141 @@
+package io
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWorkspace(t *testing.T) {
+	root, err := ioutil.TempDir("", "seneca-ws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		ws, err := NewWorkspace(root)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(root, APPDIR), filepath.Dir(ws.Dir))
+		assert.False(t, seen[ws.Dir], "%s reused", ws.Dir)
+		seen[ws.Dir] = true
+
+		pid, err := ioutil.ReadFile(filepath.Join(ws.Dir, LOCKFILE))
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(os.Getpid()), string(pid))
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	root, err := ioutil.TempDir("", "seneca-ws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	touch := func(vr *VideoReader) {
+		os.MkdirAll(vr.PngDir, os.ModePerm)
+		for _, name := range []string{TMPMP4, vr.Gif, CONCAT} {
+			ioutil.WriteFile(filepath.Join(vr.TmpDir, name), nil, 0644)
+		}
+	}
+
+	ws, _ := NewWorkspace(root)
+	vr := &VideoReader{Filename: "/videos/plane.mp4", Work: Work{Workspace: ws}}
+	assert.NoError(t, vr.Reset(3))
+	assert.Equal(t, ws.Dir, vr.TmpDir)
+	vr.Concat = CONCAT
+	touch(vr)
+
+	assert.NoError(t, vr.Cleanup(false))
+	left, _ := filepath.Glob(filepath.Join(ws.Dir, "*"))
+	assert.Equal(t, []string{vr.Result()}, left)
+
+	ws, _ = NewWorkspace(root)
+	vr.Workspace = ws
+	vr.Reset(3)
+	touch(vr)
+	assert.NoError(t, vr.Cleanup(true))
+	_, err = os.Stat(ws.Dir)
+	assert.True(t, os.IsNotExist(err))
+
+	// the log of a failed run is kept for a while
+	ws, _ = NewWorkspace(root)
+	vr.Workspace = ws
+	vr.Reset(3)
+	vr.Log = LOGFILE
+	touch(vr)
+	ioutil.WriteFile(vr.LogFile(), []byte("Unknown encoder"), 0644)
+	assert.NoError(t, vr.Cleanup(true))
+	left, _ = filepath.Glob(filepath.Join(ws.Dir, "*"))
+	assert.Equal(t, []string{vr.LogFile(), filepath.Join(ws.Dir, LOCKFILE)},
+		left)
+}
+
+// Without a Workspace runs started in the same second still
+// get directories of their own
+func TestResetWithoutWorkspace(t *testing.T) {
+	a := &VideoReader{Filename: "/videos/plane.mp4", Root: "/scratch"}
+	b := &VideoReader{Filename: "/videos/plane.mp4", Root: "/scratch"}
+	assert.NoError(t, a.Reset(3))
+	assert.NoError(t, b.Reset(3))
+	assert.NotEqual(t, a.TmpDir, b.TmpDir)
+	assert.Equal(t, filepath.Join("/scratch", APPDIR), filepath.Dir(a.TmpDir))
+}
+
+func TestSweep(t *testing.T) {
+	root, err := ioutil.TempDir("", "seneca-ws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	parent := filepath.Join(root, APPDIR)
+
+	// name -> pid in the lock (0 for none), frames left behind
+	layout := []struct {
+		name   string
+		pid    int
+		frames bool
+	}{
+		{"1400000000-crashed", 1 << 30, true},
+		{"1400000001-running", os.Getpid(), true},
+		{"1400000002-finished", 0, false},
+		{"1400000003", 0, true}, // older releases
+		{"jobs", 0, true},
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, l := range layout {
+		dir := filepath.Join(parent, l.name)
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+		if l.pid != 0 {
+			ioutil.WriteFile(filepath.Join(dir, LOCKFILE),
+				[]byte(strconv.Itoa(l.pid)), 0644)
+		}
+		if l.frames {
+			os.Mkdir(filepath.Join(dir, PDIR), os.ModePerm)
+		}
+		os.Chtimes(dir, old, old)
+	}
+	young, _ := NewWorkspace(root)
+	ioutil.WriteFile(filepath.Join(young.Dir, LOCKFILE), []byte("1073741824"), 0644)
+
+	expected := []string{
+		filepath.Join(parent, "1400000000-crashed"),
+		filepath.Join(parent, "1400000003"),
+	}
+	swept, err := Sweep(root, GC_AGE, true)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, swept)
+	_, err = os.Stat(expected[0])
+	assert.NoError(t, err, "removed during a dry run")
+
+	swept, err = Sweep(root, GC_AGE, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, swept)
+	left, _ := ioutil.ReadDir(parent)
+	assert.Equal(t, 4, len(left))
+
+	swept, err = Sweep(filepath.Join(root, "missing"), GC_AGE, false)
+	assert.NoError(t, err)
+	assert.Empty(t, swept)
+}
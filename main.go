@@ -18,12 +18,14 @@ License.
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	stdio "io"
 	"net"
 	"os"
-	"path/filepath"
-	"runtime"
+	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/javouhey/seneca/io"
@@ -35,134 +37,343 @@ var (
 	GitSHA  string
 	Version string
 
-	listener net.Listener
-	ipc      chan progress.Status
+	errMissingProgram = errors.New("ffmpeg or ffprobe is missing")
 
-	task1 *io.FrameGenerator
-	task2 *io.Muxer
-	task3 *io.GifWriter
+	// subcommands e.g. `seneca scenes <video>`
+	commands = map[string]func([]string) int{
+		"scenes": runScenes,
+		"gc":     runGc,
+		"serve":  runServe,
+		"watch":  runWatch,
+	}
 )
 
 func main() {
+	syscall.Exit(run(os.Args))
+}
 
-	if len(os.Args) == 1 {
+// run returns the exit status rather than exiting so that the
+// deferred cleanup always happens.
+func run(argv []string) (code int) {
+
+	if len(argv) == 1 {
 		fmt.Printf("%s", util.HelpMessage)
-		syscall.Exit(0)
+		return 0
 	}
 
-	args := util.NewArguments()
-	if err := args.Parse(os.Args[1:]); err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
-		syscall.Exit(1)
+	if command, ok := commands[argv[1]]; ok {
+		return command(argv[2:])
 	}
 
-	if args.Verbose {
-		fmt.Printf("  %#v\n", args)
+	args := util.NewArguments()
+	if err := args.Parse(argv[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		if wantsJson(argv[1:]) {
+			sum := newSummary(args)
+			sum.fail(err)
+			sum.write(os.Stdout, 1)
+		}
+		return 1
 	}
 
 	if args.Version {
 		printVersion()
-		syscall.Exit(0)
+		return 0
 	}
 
 	if args.Help {
 		fmt.Printf("%s", util.HelpMessage)
-		syscall.Exit(0)
+		return 0
+	}
+
+	// with -json stdout carries nothing but the summary
+	var sum *summary
+	var out stdio.Writer = os.Stdout
+	if args.Json {
+		sum = newSummary(args)
+		out = os.Stderr
+		defer func() { sum.write(os.Stdout, code) }()
 	}
 
 	if err := args.Validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
-		syscall.Exit(1)
+		sum.fail(err)
+		return 1
+	}
+	args.ConfigureLog(args.Verbose)
+	util.Log.Debug("arguments", "args", fmt.Sprintf("%#v", args))
+
+	if code := configurePrograms(args.Ffmpeg, args.Ffprobe); code != 0 {
+		sum.fail(errMissingProgram)
+		return code
+	}
+
+	// opportunistic, `seneca gc` does the same on demand
+	if swept, err := io.Sweep(args.WorkDir, io.GC_AGE, false); err != nil {
+		util.Log.Warn("sweeping workspaces failed", "error", err)
+	} else if len(swept) > 0 {
+		util.Log.Debug("swept abandoned workspaces", "count", len(swept))
+	}
+
+	// From here on a signal stops the run. Reading stdin cannot
+	// be stopped, so it is left to end the default way.
+	var stop <-chan struct{}
+	caught, release := func() os.Signal { return nil }, func() {}
+	if !util.IsStdin(args.VideoIn) {
+		stop, caught, release = closeOnSignal()
+	}
+	input, err := io.OpenInput(args.VideoIn, args.WorkDir, args.MaxInput<<20,
+		stop)
+	if stop == nil {
+		stop, caught, release = closeOnSignal()
+	}
+	defer release()
+	if sig := caught(); sig != nil {
+		sum.fail(fmt.Errorf("interrupted by %s", sig))
+		return signalStatus(sig)
+	}
+	if err != nil {
+		util.Log.Error("unable to read input", "source", args.VideoIn,
+			"error", err)
+		sum.fail(err)
+		return 1
 	}
+	defer input.Remove()
 
 	var vr *io.VideoReader
 	var errVr error
 
-	filename, _ := util.SanitizeFile(args.VideoIn)
-	vr, errVr = io.NewVideoReader(filename, args.DryRun)
+	filename := input.File
+	vr, errVr = io.NewVideoReaderTo(filename, args.DryRun, out)
+	if sig := caught(); sig != nil {
+		sum.fail(fmt.Errorf("interrupted by %s", sig))
+		return signalStatus(sig)
+	}
 	if errVr != nil {
-		fmt.Fprintf(os.Stderr, io.INVALID_VIDEO, filename, util.ShortHelp)
-		syscall.Exit(1)
+		fmt.Fprintf(os.Stderr, io.INVALID_VIDEO, input.Source, util.ShortHelp)
+		sum.fail(fmt.Errorf("%q is not a recognizable video file",
+			input.Source))
+		return 1
 	}
+	vr.Root = args.WorkDir
+	vr.Log = io.LOGFILE
+	sum.video(vr, input.Source, args)
 
-	if args.Verbose {
-		fmt.Printf("%s", vr)
+	util.Log.Debug("probed video", "file", vr.Filename,
+		"duration", vr.Duration, "unknown_duration", vr.UnknownDuration,
+		"size", vr.VideoSize, "rotation", vr.Rotation,
+		"sar", vr.SampleAspect, "dar", vr.DisplayAspect, "fps", vr.Fps)
+
+	if err := vr.ResolveWindow(args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		sum.fail(err)
+		return 1
+	}
+	if args.ByFrame() {
+		util.Log.Debug("resolved frame numbers", "rate", vr.Rate,
+			"from", args.From, "length", args.Length)
+		if args.DryRun {
+			fmt.Fprintf(out, "  frames at %s fps: -from %s -length %s\n",
+				vr.Rate, args.From, args.Length)
+		}
 	}
 
 	// --- setup progress notification ---
-	listener := NewTCPListener(args.Port)
+	ipc := make(chan progress.Status)
+	listener, err := NewTCPListener(args.Port)
+	if err != nil {
+		sum.fail(err)
+		return 1
+	}
 
 	defer func() {
-		cleanup(vr)
+		cleanup(vr, code != 0)
 
 		listener.Close()
-		if args.Verbose {
-			fmt.Println("Closed TCP listener")
-		}
+		util.Log.Debug("closed TCP listener")
 		close(ipc)
-		if args.Verbose {
-			fmt.Println("Closed progress channel")
-		}
+		util.Log.Debug("closed progress channel")
 	}()
 
-	go progress.StatusLogger(ipc)
+	go progress.StatusLogger(out, ipc)
 	go progress.Progress(listener, ipc, args.Port)
 
+	// --- Preview ---
+	if args.Preview {
+		pargs := args.PreviewArguments()
+		// the full render then shares its workspace
+		pipeline, sig, err := render(vr, pargs, stop, caught)
+		if code := renderStatus(vr, sum, sig, err); code != 0 {
+			return code
+		}
+		if !args.DryRun {
+			if !showPreview(out, vr, args, os.Stdin, stop) {
+				if sig := caught(); sig != nil {
+					sum.fail(fmt.Errorf("interrupted by %s", sig))
+					return signalStatus(sig)
+				}
+				sum.pipeline(vr, pargs, pipeline)
+				return 0
+			}
+		}
+		// -auto-clip need not search again
+		args.From, args.AutoClip = pargs.From, false
+	}
+
 	// --- Pipeline ---
-	reply := task1.Run(vr, args)
-	if err := <-reply; err != nil {
-		syscall.Exit(126)
+	pipeline, sig, err := render(vr, args, stop, caught)
+	sum.pipeline(vr, args, pipeline)
+	if code := renderStatus(vr, sum, sig, err); code != 0 {
+		return code
 	}
 
-	wg := task2.Run(vr, args)
-	wg.Wait()
-	if err := task2.Error(); err != nil {
-		syscall.Exit(126)
+	if args.Sheet {
+		sayGoodbye(out, vr, "contact sheet")
+	} else {
+		sayGoodbye(out, vr, "animated GIF")
 	}
+	return 0
+}
 
-	task3.Run(vr, args)
-	/*
-	   Sample code for cancelling a goroutine
+// Runs a pipeline to its end, or until stop is closed. sig is
+// the signal that stopped it, as reported by caught.
+func render(vr *io.VideoReader, args *util.Arguments, stop <-chan struct{},
+	caught func() os.Signal) (pipeline *io.Pipeline, sig os.Signal, err error) {
+
+	pipeline = new(io.Pipeline)
+	pipeline.Run(vr, args)
+	go func() {
+		select {
+		case <-stop:
+			pipeline.Tombstone.Kill(io.ErrCancelled)
+		case <-pipeline.Tombstone.Dead():
+		}
+	}()
+
+	err = pipeline.Tombstone.Wait()
+	return pipeline, caught(), err
+}
 
-	   time.Sleep(1 * time.Second)
-	   log.Fatal(task3.Stop())
-	*/
-	if err := task3.Tombstone.Wait(); err != nil {
-		syscall.Exit(126)
+// Exit status of a render, 0 when it succeeded
+func renderStatus(vr *io.VideoReader, sum *summary, sig os.Signal, err error) int {
+	if sig != nil {
+		util.Log.Warn("interrupted, removing workspace",
+			"signal", sig, "dir", vr.TmpDir)
+		// nothing to diagnose, Cleanup removes the log too
+		vr.Log = ""
+		sum.fail(fmt.Errorf("interrupted by %s", sig))
+		return signalStatus(sig)
 	}
+	if err != nil {
+		// Cleanup keeps it
+		log := vr.LogFile()
+		if _, serr := os.Stat(log); serr != nil {
+			log = ""
+		}
+		util.Log.Error("failed, see the ffmpeg log", "error", err, "log", log)
+		sum.fail(err)
+		sum.keepLog(log)
+		return 126
+	}
+	return 0
+}
 
-	sayGoodbye(vr)
+// Closes stop on SIGINT or SIGTERM, until release. caught
+// reports the signal once stop is closed, nil before.
+func closeOnSignal() (stop <-chan struct{}, caught func() os.Signal,
+	release func()) {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	cancel, done := make(chan struct{}), make(chan struct{})
+	var sig os.Signal
+	go func() {
+		select {
+		case sig = <-signals:
+			close(cancel)
+		case <-done:
+		}
+	}()
+	caught = func() os.Signal {
+		select {
+		case <-cancel:
+			return sig
+		default:
+			return nil
+		}
+	}
+	return cancel, caught, func() {
+		signal.Stop(signals)
+		close(done)
+	}
 }
 
-func init() {
-	ipc = make(chan progress.Status)
-	task1 = new(io.FrameGenerator)
-	task2 = new(io.Muxer)
-	task3 = new(io.GifWriter)
-	runtime.GOMAXPROCS(3)
+// 128 + signal number, as shells report it e.g. 130 for SIGINT
+func signalStatus(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 128 + int(syscall.SIGINT)
+}
+
+// Exit status 127 when either ffmpeg or ffprobe is unusable
+func configurePrograms(ffmpeg, ffprobe string) int {
+	err := io.Configure(io.Programs{Ffmpeg: ffmpeg, Ffprobe: ffprobe})
+	if err != nil {
+		prog := "ffmpeg"
+		if perr, ok := err.(*io.ProgramError); ok {
+			prog = perr.Program
+		}
+		fmt.Fprintf(os.Stderr, io.MISSING_PROG, prog, util.ShortHelp)
+		return 127
+	}
+	return 0
 }
 
-func NewTCPListener(port int) net.Listener {
+func NewTCPListener(port int) (net.Listener, error) {
 	listener, err := net.Listen("tcp", util.ToPort(port))
 	if err != nil {
-		log.Fatal(err)
+		util.Log.Error("unable to listen for progress", "port", port,
+			"error", err)
 	}
-	return listener
+	return listener, err
 }
 
-func cleanup(vr *io.VideoReader) {
-	if vr != nil && !util.IsEmpty(vr.PngDir) {
-		if err := os.RemoveAll(vr.PngDir); err != nil {
-			fmt.Printf("WARNING: Removing %s encountered errors\n", vr.PngDir)
-			fmt.Printf("\t%s", err.Error())
+// Keeps only the result, or nothing at all when failed
+func cleanup(vr *io.VideoReader, failed bool) {
+	if vr == nil {
+		return
+	}
+	if err := vr.Cleanup(failed); err != nil {
+		util.Log.Warn("unable to clean up workspace", "dir", vr.TmpDir,
+			"error", err)
+	}
+}
+
+// Whether -json is among arguments that may not parse
+func wantsJson(argv []string) bool {
+	for _, arg := range argv {
+		name := strings.TrimLeft(arg, "-")
+		if arg == "--" || name == arg {
+			continue
+		}
+		value := "true"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		if name == "json" {
+			on, err := strconv.ParseBool(value)
+			return err == nil && on
 		}
 	}
+	return false
 }
 
-func sayGoodbye(vr *io.VideoReader) {
+func sayGoodbye(out stdio.Writer, vr *io.VideoReader, kind string) {
 	if vr != nil && !util.IsEmpty(vr.TmpDir) {
-		fmt.Println("\n\nYour animated GIF is ready at location:")
-		fmt.Printf("  %s\n\n", filepath.Join(vr.TmpDir, vr.Gif))
+		fmt.Fprintf(out, "\n\nYour %s is ready at location:\n", kind)
+		fmt.Fprintf(out, "  %s\n\n", vr.Result())
 	}
 }
 
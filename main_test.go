@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/io/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func setupRun(t *testing.T) (*fake.Runner, string, func()) {
+	r := fake.NewRunner()
+	r.On("ffprobe").Stderr = fake.Probe(60*time.Second, 640, 480, 25)
+	io.SetRunner(r)
+
+	dir, err := ioutil.TempDir("", "seneca-main")
+	assert.NoError(t, err)
+	video := filepath.Join(dir, "plane.mp4")
+	assert.NoError(t, ioutil.WriteFile(video, nil, 0644))
+	// work directories end up next to the video
+	tmp := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", dir)
+	return r, video, func() {
+		os.Setenv("TMPDIR", tmp)
+		os.RemoveAll(dir)
+		io.SetRunner(nil)
+	}
+}
+
+// The output of the last ffmpeg and the frames of the first
+func outputs(r *fake.Runner) (string, string) {
+	calls := r.Calls()
+	last := calls[len(calls)-1]
+	for _, call := range calls {
+		if call[len(call)-1] != "-h" && filepath.Ext(call[len(call)-1]) == ".png" {
+			return filepath.Dir(call[len(call)-1]), last[len(last)-1]
+		}
+	}
+	return "", last[len(last)-1]
+}
+
+func TestRunSuccess(t *testing.T) {
+	r, video, teardown := setupRun(t)
+	defer teardown()
+
+	code := run([]string{"seneca", "-port", freePort(t), "-video-infile", video})
+	assert.Equal(t, 0, code)
+
+	pngDir, gif := outputs(r)
+	_, err := os.Stat(gif)
+	assert.NoError(t, err)
+	_, err = os.Stat(pngDir)
+	assert.True(t, os.IsNotExist(err), "frames were not cleaned up")
+}
+
+func TestRunPreview(t *testing.T) {
+	r, video, teardown := setupRun(t)
+	defer teardown()
+
+	// stdin is no terminal: no questions, only the preview
+	code := run([]string{"seneca", "-port", freePort(t), "-fps", "20",
+		"-preview", "-video-infile", video})
+	assert.Equal(t, 0, code)
+
+	var gif string
+	for _, call := range r.Calls() {
+		assert.NotContains(t, call, "libx264")
+		switch {
+		case call[len(call)-1] == "pipe:1":
+			assert.Contains(t, call, "scale=160:trunc(ow/dar/2)*2,setsar=1")
+			assert.Contains(t, call, "5")
+		case strings.Contains(strings.Join(call, " "), "pipe:0"):
+			gif = call[len(call)-1]
+		}
+	}
+	_, err := os.Stat(filepath.Join(filepath.Dir(gif), "plane-preview.gif"))
+	assert.NoError(t, err)
+
+	assert.True(t, confirm(ioutil.Discard, strings.NewReader("Y\n"), ""))
+	assert.False(t, confirm(ioutil.Discard, strings.NewReader("\n"), ""))
+	var buf bytes.Buffer
+	assert.NoError(t, inlineImage(&buf, video))
+	assert.True(t, strings.HasPrefix(buf.String(), "\033]1337;File="))
+}
+
+// A preview streams the whole window, however long
+func TestRunPreviewLongWindow(t *testing.T) {
+	for _, window := range [][]string{
+		{"-length", "60s"},
+		{"-from-frame", "0", "-to-frame", "1499", "-parallel", "4"},
+	} {
+		r, video, teardown := setupRun(t)
+		options := append([]string{"seneca", "-port", freePort(t), "-preview",
+			"-video-infile", video}, window...)
+		assert.Equal(t, 0, run(options), "%v", window)
+
+		var extracts int
+		for _, call := range r.Calls() {
+			if call[len(call)-1] == "pipe:1" {
+				extracts++
+				assert.Contains(t, strings.Join(call, " "), "-t 60 ")
+			}
+		}
+		assert.Equal(t, 1, extracts, "%v", window)
+		teardown()
+	}
+}
+
+func TestRunFfmpegFails(t *testing.T) {
+	r, video, teardown := setupRun(t)
+	defer teardown()
+
+	rule := r.On("libx264")
+	rule.Fail, rule.Stderr = 1, "Unknown encoder 'libx264'\n"
+	code := run([]string{"seneca", "-port", freePort(t), "-video-infile", video})
+	assert.Equal(t, 126, code)
+
+	pngDir, _ := outputs(r)
+	_, err := os.Stat(pngDir)
+	assert.True(t, os.IsNotExist(err), "frames were not cleaned up")
+
+	// only the log of ffmpeg's stderr is left to look at
+	log, err := ioutil.ReadFile(filepath.Join(filepath.Dir(pngDir), io.LOGFILE))
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "Unknown encoder")
+}
+
+func TestRunMissingProgram(t *testing.T) {
+	r, video, teardown := setupRun(t)
+	defer teardown()
+
+	r.On("ffmpeg -h").Fail = 1
+	code := run([]string{"seneca", "-port", freePort(t), "-video-infile", video})
+	assert.Equal(t, 127, code)
+}
+
+func TestRunBadArguments(t *testing.T) {
+	code := run([]string{"seneca", "-fps", "abc", "-video-infile", "x.mp4"})
+	assert.Equal(t, 1, code)
+}
+
+// Runs with -json & decodes what was printed on stdout
+func runJson(t *testing.T, video string, options ...string) (int, *summary) {
+	out, err := ioutil.TempFile(filepath.Dir(video), "stdout")
+	assert.NoError(t, err)
+	defer out.Close()
+
+	stdout := os.Stdout
+	os.Stdout = out
+	code := run(append([]string{"seneca", "-json", "-port", freePort(t),
+		"-video-infile", video}, options...))
+	os.Stdout = stdout
+
+	data, err := ioutil.ReadFile(out.Name())
+	assert.NoError(t, err)
+	sum := new(summary)
+	assert.NoError(t, json.Unmarshal(data, sum), string(data))
+	return code, sum
+}
+
+func TestRunJson(t *testing.T) {
+	_, video, teardown := setupRun(t)
+	defer teardown()
+
+	code, sum := runJson(t, video)
+	assert.Equal(t, 0, code)
+	assert.True(t, sum.Ok)
+	assert.Equal(t, 0, sum.ExitCode)
+	assert.Equal(t, &input{File: video, Duration: 60, Sar: "1:1",
+		Dar: "4:3", Width: 640, Height: 480, Fps: 25}, sum.Input)
+	assert.Equal(t, 25, sum.Parameters.Fps)
+	if assert.NotNil(t, sum.Output) {
+		assert.Equal(t, "gif", sum.Output.Kind)
+		assert.Equal(t, 3, sum.Output.Frames)
+		assert.True(t, sum.Output.Bytes > 0)
+	}
+	names := make([]string, 0)
+	for _, stage := range sum.Stages {
+		names = append(names, stage.Name)
+	}
+	assert.Equal(t, []string{"frames", "mux", "gif"}, names)
+}
+
+func TestRunJsonFailure(t *testing.T) {
+	r, video, teardown := setupRun(t)
+	defer teardown()
+
+	r.On("libx264").Fail = 1
+	code, sum := runJson(t, video)
+	assert.Equal(t, 126, code)
+	assert.False(t, sum.Ok)
+	assert.Equal(t, 126, sum.ExitCode)
+	assert.Equal(t, "ffmpeg: exit status 1", sum.Error)
+	assert.Equal(t, io.LOGFILE, filepath.Base(sum.Log))
+	assert.Nil(t, sum.Output)
+	assert.Equal(t, 2, len(sum.Stages))
+}
+
+// The commands go to stderr, stdout is only the summary
+func TestRunJsonDryRun(t *testing.T) {
+	_, video, teardown := setupRun(t)
+	defer teardown()
+
+	code, sum := runJson(t, video, "-dry-run", "-dedup", "-auto-clip")
+	assert.Equal(t, 0, code)
+	assert.True(t, sum.Ok)
+	assert.True(t, sum.Parameters.DryRun)
+	assert.Nil(t, sum.Output)
+}
+
+func TestRunJsonBadArguments(t *testing.T) {
+	_, video, teardown := setupRun(t)
+	defer teardown()
+
+	code, sum := runJson(t, video, "-fps", "abc")
+	assert.Equal(t, 1, code)
+	assert.False(t, sum.Ok)
+	assert.Equal(t, 1, sum.ExitCode)
+	assert.Contains(t, sum.Error, "abc")
+	assert.Nil(t, sum.Input)
+
+	assert.True(t, wantsJson([]string{"-fps", "abc", "--json"}))
+	assert.True(t, wantsJson([]string{"-json=1"}))
+	assert.False(t, wantsJson([]string{"-json=false"}))
+	assert.False(t, wantsJson([]string{"-video-infile", "json"}))
+}
+
+func TestGifFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seneca-main")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	anim := filepath.Join(dir, "anim.gif")
+	assert.NoError(t, ioutil.WriteFile(anim, fake.Gif(5), 0644))
+	assert.Equal(t, 5, gifFrames(anim))
+
+	png := filepath.Join(dir, "frame.png")
+	assert.NoError(t, ioutil.WriteFile(png, fake.Frame(1), 0644))
+	assert.Equal(t, 0, gifFrames(png))
+	assert.Equal(t, 0, gifFrames(filepath.Join(dir, "missing.gif")))
+}
+
+func TestRunInterrupted(t *testing.T) {
+	r, video, teardown := setupRun(t)
+	defer teardown()
+
+	timeout := io.TerminateTimeout
+	io.TerminateTimeout = 50 * time.Millisecond
+	defer func() { io.TerminateTimeout = timeout }()
+
+	rule := r.On("image2 -vsync cfr")
+	rule.Delay, rule.IgnoreTerm = time.Minute, true
+
+	codes := make(chan int)
+	go func() {
+		codes <- run([]string{"seneca", "-port", freePort(t),
+			"-video-infile", video})
+	}()
+	for len(r.Calls()) < 4 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	self, _ := os.FindProcess(os.Getpid())
+	assert.NoError(t, self.Signal(syscall.SIGTERM))
+
+	select {
+	case code := <-codes:
+		assert.Equal(t, 128+int(syscall.SIGTERM), code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ffmpeg was not killed")
+	}
+	pngDir, _ := outputs(r)
+	_, err := os.Stat(filepath.Dir(pngDir))
+	assert.True(t, os.IsNotExist(err), "work directory was not removed")
+}
+
+func TestRunUrl(t *testing.T) {
+	r, video, teardown := setupRun(t)
+	defer teardown()
+
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			http.ServeFile(w, req, video)
+		}))
+	defer ts.Close()
+
+	code := run([]string{"seneca", "-port", freePort(t),
+		"-video-infile", ts.URL + "/builds/42/demo.mp4?dl=1"})
+	assert.Equal(t, 0, code)
+
+	calls := r.Calls()
+	spooled := calls[2][1]
+	assert.Equal(t, "demo.mp4", filepath.Base(spooled))
+	_, err := os.Stat(spooled)
+	assert.True(t, os.IsNotExist(err), "spooled input was not removed")
+	_, gif := outputs(r)
+	assert.Equal(t, "demo.gif", filepath.Base(gif))
+}
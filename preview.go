@@ -0,0 +1,92 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	stdio "io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/util"
+)
+
+const PREVIEW_SUFFIX = "-preview.gif"
+
+// Moves the preview out of the way of the full render & shows
+// it. True when the full render should follow, which only a
+// terminal on stdin can ask for. Closing stop gives up asking.
+func showPreview(out stdio.Writer, vr *io.VideoReader, args *util.Arguments,
+	in *os.File, stop <-chan struct{}) bool {
+
+	preview := strings.TrimSuffix(vr.Gif, ".gif") + PREVIEW_SUFFIX
+	if err := os.Rename(vr.Result(),
+		filepath.Join(vr.TmpDir, preview)); err != nil {
+		util.Log.Warn("unable to keep the preview", "error", err)
+		return false
+	}
+	vr.Gif = preview
+
+	fmt.Fprintf(out, "\n\nYour preview is ready at location:\n")
+	fmt.Fprintf(out, "  %s\n\n", vr.Result())
+	if args.PreviewInline {
+		if err := inlineImage(out, vr.Result()); err != nil {
+			util.Log.Warn("unable to show the preview", "error", err)
+		}
+	}
+
+	if fi, err := in.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	answer := make(chan bool, 1)
+	go func() {
+		answer <- confirm(out, in, "Continue with the full render? [y/N] ")
+	}()
+	select {
+	case yes := <-answer:
+		return yes
+	case <-stop:
+		fmt.Fprintln(out)
+		return false
+	}
+}
+
+func confirm(out stdio.Writer, in stdio.Reader, question string) bool {
+	fmt.Fprint(out, question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Shows the image in the terminal with the inline images
+// protocol of iTerm2, also understood by WezTerm & others
+func inlineImage(w stdio.Writer, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\033]1337;File=name=%s;size=%d;inline=1:%s\a\n",
+		base64.StdEncoding.EncodeToString([]byte(filepath.Base(path))),
+		len(data), base64.StdEncoding.EncodeToString(data))
+	return err
+}
@@ -22,7 +22,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"runtime"
@@ -51,11 +50,25 @@ const (
    progress=continue / end
 */
 type Status struct {
+	Job         string
 	frame       int32
 	drop_frames int32
 	progress    string
 }
 
+func (s Status) Frame() int32 {
+	return s.frame
+}
+
+func (s Status) DropFrames() int32 {
+	return s.drop_frames
+}
+
+// ffmpeg sends progress=end with its last report
+func (s Status) Finished() bool {
+	return s.progress == "end"
+}
+
 func (s *Status) parse(httpBody string) {
 	lines := strings.Split(httpBody, "\n")
 	for _, keyvaluepair := range lines {
@@ -125,7 +138,7 @@ func (h MyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			tmp = tmp[0:n]
 			buffer.Write(tmp)
 
-			status := Status{}
+			status := Status{Job: strings.Trim(r.URL.Path, "/")}
 			status.parse(buffer.String())
 			//log.Printf("%#v\n", status)
 			h.pings <- status
@@ -137,8 +150,8 @@ finish:
 	w.(http.Flusher).Flush()
 }
 
-// goroutine responsible for printing progress ticks
-func StatusLogger(q <-chan Status) {
+// goroutine responsible for printing progress ticks to w
+func StatusLogger(w io.Writer, q <-chan Status) {
 	for {
 		stat, ok := <-q
 		if !ok {
@@ -148,12 +161,12 @@ func StatusLogger(q <-chan Status) {
 		if stat.progress == "continue" {
 			switch {
 			case stat.frame == 0:
-				fmt.Printf(".")
+				fmt.Fprintf(w, ".")
 			default:
-				fmt.Printf(" %d", stat.frame)
+				fmt.Fprintf(w, " %d", stat.frame)
 			}
 		} else {
-			fmt.Printf(" Completed\n")
+			fmt.Fprintf(w, " Completed\n")
 		}
 		runtime.Gosched()
 	}
@@ -171,5 +184,6 @@ func Progress(l net.Listener, q chan<- Status, port int) {
 		MaxHeaderBytes: 1 << 20,
 	}
 	//log.Printf("HTTP server listening on port %s\n", httpPort)
-	log.Println(s.Serve(l))
+	util.Log.Debug("progress listener stopped", "port", port,
+		"error", s.Serve(l))
 }
@@ -0,0 +1,63 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/util"
+)
+
+// `seneca scenes <video>` lists the cuts found by ffmpeg
+func runScenes(arguments []string) int {
+	args := util.NewSceneArguments()
+	if err := args.Parse(arguments); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+
+	if err := args.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+	args.ConfigureLog(args.Verbose)
+
+	if code := configurePrograms(args.Ffmpeg, args.Ffprobe); code != 0 {
+		return code
+	}
+
+	filename, _ := util.SanitizeFile(args.VideoIn)
+	scenes, err := io.DetectScenes(filename, args.Threshold, args.DryRun,
+		nil, nil)
+	if err != nil {
+		util.Log.Error("scene detection failed", "file", filename, "error", err)
+		return 126
+	}
+	if args.DryRun {
+		return 0
+	}
+
+	fmt.Printf("\n  %d scene changes above %g\n", len(scenes), args.Threshold)
+	fmt.Printf("  ---------------------------\n")
+	for _, s := range scenes {
+		fmt.Printf("  %s\n", s)
+	}
+	return 0
+}
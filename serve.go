@@ -0,0 +1,91 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/javouhey/seneca/server"
+	"github.com/javouhey/seneca/util"
+)
+
+// `seneca serve` accepts GIF jobs over HTTP
+func runServe(arguments []string) int {
+	args := util.NewServeArguments()
+	if err := args.Parse(arguments); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+
+	if err := args.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+	args.ConfigureLog(args.Verbose)
+
+	if code := configurePrograms(args.Ffmpeg, args.Ffprobe); code != 0 {
+		return code
+	}
+
+	s := server.New(server.Config{
+		Port:      args.Port,
+		QueueSize: args.QueueSize,
+		Workers:   args.Workers,
+		WorkDir:   args.WorkDir,
+		MaxUpload: args.MaxUpload << 20,
+	})
+
+	progressListener, err := NewTCPListener(args.Port)
+	if err != nil {
+		return 1
+	}
+	defer progressListener.Close()
+	if err := s.Start(progressListener); err != nil {
+		util.Log.Error("unable to start server", "error", err)
+		return 1
+	}
+
+	api, err := net.Listen("tcp", args.Listen)
+	if err != nil {
+		util.Log.Error("unable to listen", "address", args.Listen, "error", err)
+		return 1
+	}
+	// a signal stops accepting, then cancels the jobs
+	stop, caught, release := closeOnSignal()
+	defer release()
+	go func() {
+		<-stop
+		api.Close()
+	}()
+
+	util.Log.Info("accepting jobs", "address", api.Addr())
+	err = http.Serve(api, s)
+	if sig := caught(); sig != nil {
+		util.Log.Info("shutting down", "signal", sig)
+		s.Shutdown()
+		return signalStatus(sig)
+	}
+	if err != nil {
+		util.Log.Error("server stopped", "error", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,501 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+// REST API that queues GIF jobs & runs them through the pipeline
+//
+//	POST   /jobs             submit {"video": <path or url>, "options": {..}}
+//	                         or a multipart upload of video & options
+//	GET    /jobs             list all jobs
+//	GET    /jobs/<id>        status & progress of a job
+//	GET    /jobs/<id>/result download the GIF (or contact sheet)
+//	DELETE /jobs/<id>        cancel a job, or forget a finished one
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdio "io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/progress"
+	"github.com/javouhey/seneca/util"
+)
+
+type State string
+
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Done      State = "done"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+)
+
+var (
+	ErrQueueFull = errors.New("job queue is full")
+	ErrNoVideo   = errors.New("a video path or upload is required")
+	ErrStdin     = errors.New("jobs cannot read the video from stdin")
+	ErrStopping  = errors.New("server is shutting down")
+)
+
+type Job struct {
+	Id       string                 `json:"id"`
+	State    State                  `json:"state"`
+	Video    string                 `json:"video"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Stage    int                    `json:"stage"`
+	Frame    int32                  `json:"frame"`
+	Error    string                 `json:"error,omitempty"`
+	Created  time.Time              `json:"created"`
+	Started  *time.Time             `json:"started,omitempty"`
+	Finished *time.Time             `json:"finished,omitempty"`
+
+	dir      string
+	result   string
+	args     *util.Arguments
+	pipeline *io.Pipeline
+	stop     chan struct{} // closed by cancel while Running
+}
+
+func (j *Job) active() bool {
+	return j.State == Queued || j.State == Running
+}
+
+type Config struct {
+	Port      int // ffmpeg posts progress here
+	QueueSize int // jobs waiting beyond this are refused
+	Workers   int // jobs running concurrently
+	WorkDir   string
+	MaxUpload int64 // bytes
+}
+
+type Server struct {
+	Config
+
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	queue    chan *Job
+	pings    chan progress.Status
+	stopping bool           // by Shutdown, no more jobs
+	running  sync.WaitGroup // jobs in run
+}
+
+func New(c Config) *Server {
+	if util.IsEmpty(c.WorkDir) {
+		c.WorkDir = filepath.Join(os.TempDir(), io.APPDIR, "jobs")
+	}
+	return &Server{
+		Config: c,
+		jobs:   make(map[string]*Job),
+		queue:  make(chan *Job, c.QueueSize),
+		pings:  make(chan progress.Status),
+	}
+}
+
+// Starts the workers & the progress listener on l
+func (s *Server) Start(l net.Listener) error {
+	if err := os.MkdirAll(s.WorkDir, os.ModePerm); err != nil {
+		return err
+	}
+	go progress.Progress(l, s.pings, s.Port)
+	go s.track()
+	for i := 0; i < s.Workers; i++ {
+		go s.work()
+	}
+	return nil
+}
+
+// Each ffmpeg in a job reports to /<job id>
+func (s *Server) track() {
+	for stat := range s.pings {
+		s.mu.Lock()
+		if job, ok := s.jobs[stat.Job]; ok {
+			job.Frame = stat.Frame()
+			if stat.Finished() {
+				job.Stage++
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) work() {
+	for job := range s.queue {
+		s.run(job)
+	}
+}
+
+func (s *Server) run(job *Job) {
+	s.mu.Lock()
+	if job.State != Queued {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.State, job.Started = Running, &now
+	job.stop = make(chan struct{})
+	s.running.Add(1)
+	s.mu.Unlock()
+	defer s.running.Done()
+
+	// URLs are downloaded into the job directory
+	input, err := io.OpenInput(job.Video, job.dir, s.MaxUpload, job.stop)
+	if err != nil {
+		s.finish(job, err)
+		return
+	}
+	defer input.Remove()
+
+	vr, err := io.NewVideoReader(input.File, job.args.DryRun)
+	if err != nil {
+		s.finish(job, fmt.Errorf("%q is not a recognizable video file", job.Video))
+		return
+	}
+	vr.Root = job.dir
+
+	p := new(io.Pipeline)
+	s.mu.Lock()
+	if job.State == Cancelled {
+		s.mu.Unlock()
+		s.finish(job, io.ErrCancelled)
+		return
+	}
+	job.pipeline = p
+	p.Run(vr, job.args)
+	s.mu.Unlock()
+
+	err = p.Tombstone.Wait()
+	vr.Cleanup(err != nil)
+	s.mu.Lock()
+	job.result = vr.Result()
+	s.mu.Unlock()
+	s.finish(job, err)
+}
+
+func (s *Server) finish(job *Job, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	job.Finished = &now
+	switch {
+	case job.State == Cancelled:
+	case err == io.ErrCancelled:
+		job.State = Cancelled
+	case err != nil:
+		job.State, job.Error = Failed, err.Error()
+	default:
+		job.State = Done
+	}
+	if job.State == Failed {
+		util.Log.Error("job finished", "id", job.Id, "state", job.State,
+			"error", job.Error)
+	} else {
+		util.Log.Info("job finished", "id", job.Id, "state", job.State)
+	}
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Parses & validates the options exactly like the cli does
+func (s *Server) newJob(video string, options map[string]interface{},
+	dir string) (*Job, error) {
+
+	if util.IsStdin(video) {
+		return nil, ErrStdin
+	}
+	argv, err := util.OptionArgs(options)
+	if err != nil {
+		return nil, err
+	}
+	args := util.NewArguments()
+	if err := args.Parse(argv); err != nil {
+		return nil, err
+	}
+	args.VideoIn = video
+	args.Port = s.Port
+	if err := args.Validate(); err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		Id:      newId(),
+		State:   Queued,
+		Video:   video,
+		Options: options,
+		Created: time.Now(),
+		dir:     dir,
+		args:    args,
+	}
+	args.Job = job.Id
+	return job, nil
+}
+
+type submission struct {
+	Video   string                 `json:"video"`
+	Options map[string]interface{} `json:"options"`
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	dir, err := ioutil.TempDir(s.WorkDir, "job-")
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var sub submission
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		err = s.receive(w, r, dir, &sub)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&sub)
+	}
+	if err == nil && util.IsEmpty(sub.Video) {
+		err = ErrNoVideo
+	}
+
+	var job *Job
+	if err == nil {
+		job, err = s.newJob(sub.Video, sub.Options, dir)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	if s.stopping {
+		err = ErrStopping
+	} else {
+		select {
+		case s.queue <- job:
+			s.jobs[job.Id] = job
+		default:
+			err = ErrQueueFull
+		}
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		os.RemoveAll(dir)
+		httpError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.Id)
+	s.reply(w, http.StatusAccepted, job)
+}
+
+// Saves the uploaded "video" part into dir
+func (s *Server) receive(w http.ResponseWriter, r *http.Request, dir string,
+	sub *submission) error {
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxUpload)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return err
+	}
+	if raw := r.FormValue("options"); !util.IsEmpty(raw) {
+		if err := json.Unmarshal([]byte(raw), &sub.Options); err != nil {
+			return err
+		}
+	}
+
+	src, header, err := r.FormFile("video")
+	if err != nil {
+		return ErrNoVideo
+	}
+	defer src.Close()
+
+	name := filepath.Base(header.Filename)
+	if name == "." || name == string(filepath.Separator) {
+		return ErrNoVideo
+	}
+	dst, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err = stdio.Copy(dst, src); err != nil {
+		return err
+	}
+	sub.Video = dst.Name()
+	return nil
+}
+
+func (s *Server) cancel(w http.ResponseWriter, job *Job) {
+	s.mu.Lock()
+	if !job.active() {
+		delete(s.jobs, job.Id)
+		s.mu.Unlock()
+		os.RemoveAll(job.dir)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	p := s.stop(job)
+	s.mu.Unlock()
+
+	if p != nil {
+		p.Stop()
+	}
+	s.reply(w, http.StatusAccepted, job)
+}
+
+// Marks an active job cancelled & returns its pipeline, if it
+// has one, for the caller to stop once s.mu is released
+func (s *Server) stop(job *Job) *io.Pipeline {
+	if job.State == Queued {
+		now := time.Now()
+		job.Finished = &now
+	}
+	job.State = Cancelled
+	if job.stop != nil {
+		close(job.stop)
+	}
+	return job.pipeline
+}
+
+// Shutdown refuses new jobs, cancels the active ones & waits
+// for those running to clean up
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	s.stopping = true
+	var pipelines []*io.Pipeline
+	for _, job := range s.jobs {
+		if !job.active() {
+			continue
+		}
+		if p := s.stop(job); p != nil {
+			pipelines = append(pipelines, p)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, p := range pipelines {
+		p.Stop()
+	}
+	s.running.Wait()
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request, job *Job) {
+	s.mu.Lock()
+	state, result := job.State, job.result
+	s.mu.Unlock()
+
+	if state != Done {
+		httpError(w, http.StatusConflict,
+			fmt.Errorf("job %s is %s", job.Id, state))
+		return
+	}
+	http.ServeFile(w, r, result)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "jobs" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case "POST":
+			s.submit(w, r)
+		case "GET":
+			s.list(w)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	job, ok := s.jobs[parts[1]]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && parts[2] == "result" && r.Method == "GET":
+		s.download(w, r, job)
+	case len(parts) == 2 && r.Method == "GET":
+		s.reply(w, http.StatusOK, job)
+	case len(parts) == 2 && r.Method == "DELETE":
+		s.cancel(w, job)
+	case len(parts) == 3 && parts[2] != "result":
+		http.NotFound(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	s.mu.Unlock()
+
+	sort.Sort(byCreated(jobs))
+	s.reply(w, http.StatusOK, jobs)
+}
+
+func (s *Server) reply(w http.ResponseWriter, code int, v interface{}) {
+	s.mu.Lock()
+	body, err := json.Marshal(v)
+	s.mu.Unlock()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+func httpError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+type byCreated []*Job
+
+func (b byCreated) Len() int           { return len(b) }
+func (b byCreated) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byCreated) Less(i, j int) bool { return b[i].Created.Before(b[j].Created) }
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/io/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T, queue int) (*Server, func()) {
+	tmp, err := ioutil.TempDir("", "seneca-server")
+	assert.NoError(t, err)
+	s := New(Config{Port: 8080, QueueSize: queue, Workers: 1,
+		WorkDir: tmp, MaxUpload: 1 << 20})
+	return s, func() { os.RemoveAll(tmp) }
+}
+
+func do(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	var m map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+	return m
+}
+
+func TestSubmitAndCancel(t *testing.T) {
+	s, done := newTestServer(t, 4)
+	defer done()
+
+	w := do(s, "POST", "/jobs", `{"options": {"fps": 10}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(s, "POST", "/jobs", `{"video": "server.go", "options": {"fps": 99}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, decode(t, w)["error"], "-fps 99")
+
+	w = do(s, "POST", "/jobs", `{"video": "server.go", "options": {"port": 9999}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(s, "POST", "/jobs", `{"video": "server.go",
+		"options": {"fps": 10, "length": "2s", "dedup": true}}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	job := decode(t, w)
+	id := job["id"].(string)
+	assert.Equal(t, "queued", job["state"])
+	assert.Equal(t, "/jobs/"+id, w.Header().Get("Location"))
+
+	job = decode(t, do(s, "GET", "/jobs/"+id, ""))
+	assert.Equal(t, "queued", job["state"])
+	assert.Equal(t, "http://127.0.0.1:8080/"+id, s.jobs[id].args.ProgressUrl())
+
+	w = do(s, "GET", "/jobs/"+id+"/result", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = do(s, "DELETE", "/jobs/"+id, "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "cancelled", decode(t, w)["state"])
+
+	// the worker skips cancelled jobs
+	s.run(<-s.queue)
+	assert.Equal(t, Cancelled, s.jobs[id].State)
+
+	w = do(s, "DELETE", "/jobs/"+id, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusNotFound, do(s, "GET", "/jobs/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(s, "GET", "/nope", "").Code)
+}
+
+func TestShutdown(t *testing.T) {
+	r := fake.NewRunner()
+	r.On("ffprobe").Stderr = fake.Probe(60*time.Second, 640, 480, 25)
+	r.On("image2 -vsync cfr").Delay = time.Minute
+	io.SetRunner(r)
+	defer io.SetRunner(nil)
+	assert.NoError(t, io.Configure(io.Programs{}))
+
+	s, done := newTestServer(t, 4)
+	defer done()
+	body := `{"video": "server.go"}`
+	queued := decode(t, do(s, "POST", "/jobs", body))["id"].(string)
+	running := decode(t, do(s, "POST", "/jobs", body))["id"].(string)
+	go s.run(s.jobs[running])
+	for {
+		s.mu.Lock()
+		started := s.jobs[running].pipeline != nil
+		s.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Shutdown()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("running job was not stopped")
+	}
+	for _, id := range []string{queued, running} {
+		assert.Equal(t, Cancelled, s.jobs[id].State)
+		assert.NotNil(t, s.jobs[id].Finished)
+	}
+	assert.Equal(t, http.StatusServiceUnavailable,
+		do(s, "POST", "/jobs", body).Code)
+}
+
+// Cancelled while probing, before its pipeline starts
+func TestCancelBeforePipeline(t *testing.T) {
+	r := fake.NewRunner()
+	rule := r.On("ffprobe")
+	rule.Stderr = fake.Probe(60*time.Second, 640, 480, 25)
+	rule.Delay = 200 * time.Millisecond
+	io.SetRunner(r)
+	defer io.SetRunner(nil)
+	assert.NoError(t, io.Configure(io.Programs{}))
+
+	s, done := newTestServer(t, 4)
+	defer done()
+	id := decode(t, do(s, "POST", "/jobs", `{"video": "server.go"}`))["id"].(string)
+	go s.run(<-s.queue)
+	for len(r.Calls()) < 3 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, http.StatusAccepted, do(s, "DELETE", "/jobs/"+id, "").Code)
+
+	s.running.Wait()
+	job := decode(t, do(s, "GET", "/jobs/"+id, ""))
+	assert.Equal(t, "cancelled", job["state"])
+	assert.NotNil(t, job["finished"])
+	assert.Equal(t, 3, len(r.Calls()))
+}
+
+func TestQueueFull(t *testing.T) {
+	s, done := newTestServer(t, 1)
+	defer done()
+
+	body := `{"video": "server.go"}`
+	assert.Equal(t, http.StatusAccepted, do(s, "POST", "/jobs", body).Code)
+	assert.Equal(t, http.StatusServiceUnavailable,
+		do(s, "POST", "/jobs", body).Code)
+
+	var jobs []map[string]interface{}
+	w := do(s, "GET", "/jobs", "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	assert.Equal(t, 1, len(jobs))
+
+	// only the accepted job keeps a work directory
+	dirs, _ := filepath.Glob(filepath.Join(s.WorkDir, "job-*"))
+	assert.Equal(t, 1, len(dirs))
+}
+
+func TestUpload(t *testing.T) {
+	s, done := newTestServer(t, 1)
+	defer done()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("options", `{"length": "1s"}`)
+	fw, _ := mw.CreateFormFile("video", "../../clip.mp4")
+	fw.Write([]byte("not really a video"))
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/jobs", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	video := decode(t, w)["video"].(string)
+	assert.Equal(t, "clip.mp4", filepath.Base(video))
+	assert.True(t, strings.HasPrefix(video, s.WorkDir))
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	stdio "io"
+	"os"
+	"strings"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/util"
+)
+
+// What -json prints on stdout once a run is over
+type summary struct {
+	Ok         bool        `json:"ok"`
+	ExitCode   int         `json:"exit_code"`
+	Error      string      `json:"error,omitempty"`
+	Log        string      `json:"log,omitempty"` // ffmpeg's stderr, kept on failure
+	Input      *input      `json:"input,omitempty"`
+	Parameters parameters  `json:"parameters"`
+	Output     *output     `json:"output,omitempty"`
+	Stages     []stageTime `json:"stages,omitempty"`
+}
+
+type input struct {
+	File            string  `json:"file"`
+	Duration        float64 `json:"duration"` // seconds
+	UnknownDuration bool    `json:"unknown_duration,omitempty"`
+	Start           float64 `json:"start,omitempty"` // seconds
+	Rotation        int     `json:"rotation,omitempty"`
+	Sar             string  `json:"sar,omitempty"`
+	Dar             string  `json:"dar,omitempty"`
+	Width           uint16  `json:"width"`
+	Height          uint16  `json:"height"`
+	Fps             float32 `json:"fps"`
+}
+
+type parameters struct {
+	From           string  `json:"from"`
+	Length         float64 `json:"length"` // seconds
+	Fps            int     `json:"fps"`
+	Scale          string  `json:"scale,omitempty"`
+	Speed          string  `json:"speed,omitempty"`
+	AutoClip       bool    `json:"auto_clip"`
+	Sheet          string  `json:"sheet,omitempty"`
+	Dedup          bool    `json:"dedup"`
+	DedupTolerance float64 `json:"dedup_tolerance,omitempty"`
+	Direct         bool    `json:"direct"`
+	SmoothLoop     float64 `json:"smooth_loop,omitempty"` // seconds
+	AutoLoop       float64 `json:"auto_loop,omitempty"`   // seconds
+	Rotate         int     `json:"rotate,omitempty"`
+	Flip           string  `json:"flip,omitempty"`
+	NoAutorotate   bool    `json:"no_autorotate,omitempty"`
+	Watermark      string  `json:"watermark,omitempty"`
+	DryRun         bool    `json:"dry_run"`
+}
+
+type output struct {
+	Kind   string `json:"kind"` // gif or sheet
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	Frames int    `json:"frames,omitempty"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Loop   *loop  `json:"loop,omitempty"`
+}
+
+// Frames numbered from 0 as extracted, the GIF ends before End
+type loop struct {
+	Start int     `json:"start_frame"`
+	End   int     `json:"end_frame"`
+	Score float64 `json:"score"` // 0 is a seamless loop
+}
+
+type stageTime struct {
+	Name    string  `json:"name"`
+	Elapsed float64 `json:"elapsed"` // seconds
+}
+
+func newSummary(args *util.Arguments) *summary {
+	return &summary{Parameters: newParameters(args)}
+}
+
+func newParameters(args *util.Arguments) parameters {
+	p := parameters{
+		From:         from(args),
+		Length:       args.Length.Seconds(),
+		Fps:          args.Fps,
+		Scale:        args.ScaleFilter,
+		Speed:        args.SpeedSpec,
+		AutoClip:     args.AutoClip,
+		Dedup:        args.Dedup,
+		Direct:       args.Direct,
+		SmoothLoop:   args.SmoothLoop.Seconds(),
+		AutoLoop:     args.AutoLoop.Seconds(),
+		Rotate:       args.Rotate,
+		Flip:         args.Flip,
+		NoAutorotate: args.NoAutorotate,
+		Watermark:    args.Watermark,
+		DryRun:       args.DryRun,
+	}
+	if args.Sheet {
+		p.Sheet = fmt.Sprintf("%dx%d", args.SheetCols, args.SheetRows)
+	}
+	if args.Dedup {
+		p.DedupTolerance = args.DedupTolerance
+	}
+	return p
+}
+
+// A nil summary, i.e. without -json, ignores everything
+
+func (s *summary) fail(err error) {
+	if s != nil && err != nil {
+		s.Error = err.Error()
+	}
+}
+
+func (s *summary) keepLog(path string) {
+	if s != nil {
+		s.Log = path
+	}
+}
+
+func from(args *util.Arguments) string {
+	if args.FromNow {
+		return "now"
+	}
+	return args.From.String()
+}
+
+// e.g. 16:9, empty when unknown
+func aspect(r util.Rational) string {
+	if !r.Valid() {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", r.Num, r.Den)
+}
+
+// The input as the output has it, turned upright or not
+func (s *summary) video(vr *io.VideoReader, source string,
+	args *util.Arguments) {
+
+	if s == nil {
+		return
+	}
+	size, sar, dar := vr.Oriented(args)
+	s.Input = &input{
+		File:            source,
+		Duration:        vr.Duration.Seconds(),
+		UnknownDuration: vr.UnknownDuration,
+		Start:           vr.Start.Seconds(),
+		Rotation:        vr.Rotation,
+		Sar:             aspect(sar),
+		Dar:             aspect(dar),
+		Width:           size.Width,
+		Height:          size.Height,
+		Fps:             vr.Fps,
+	}
+}
+
+// Must be called before the frames are cleaned up
+func (s *summary) pipeline(vr *io.VideoReader, args *util.Arguments,
+	p *io.Pipeline) {
+
+	if s == nil {
+		return
+	}
+	for _, stage := range p.Stages() {
+		s.Stages = append(s.Stages,
+			stageTime{stage.Name, stage.Elapsed.Seconds()})
+	}
+	// -auto-clip or frame numbers may have moved the window
+	s.Parameters.From = from(args)
+	s.Parameters.Length = args.Length.Seconds()
+
+	if args.DryRun || util.IsEmpty(vr.TmpDir) {
+		return
+	}
+	fi, err := os.Stat(vr.Result())
+	if err != nil {
+		return
+	}
+	out := &output{Kind: "gif", Path: vr.Result(), Bytes: fi.Size()}
+	if vr.Loop != nil {
+		out.Loop = &loop{vr.Loop.Start, vr.Loop.End, vr.Loop.Score}
+	}
+	if args.Sheet {
+		out.Kind = "sheet"
+	} else {
+		out.Frames = gifFrames(out.Path)
+	}
+	if f, err := os.Open(out.Path); err == nil {
+		if c, _, err := image.DecodeConfig(f); err == nil {
+			out.Width, out.Height = c.Width, c.Height
+		}
+		f.Close()
+	}
+	s.Output = out
+}
+
+// Counts the images of a GIF without decoding them, 0 when it
+// is not one. -stream & -dedup leave no PNG per frame to count.
+func gifFrames(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	// header & logical screen descriptor
+	head := make([]byte, 13)
+	if _, err := stdio.ReadFull(r, head); err != nil ||
+		!strings.HasPrefix(string(head), "GIF8") {
+		return 0
+	}
+	if err := skipColorTable(r, head[10]); err != nil {
+		return 0
+	}
+	frames := 0
+	for {
+		block, err := r.ReadByte()
+		if err != nil {
+			return 0
+		}
+		switch block {
+		case 0x21: // extension: label then sub-blocks
+			if _, err := r.ReadByte(); err != nil {
+				return 0
+			}
+		case 0x2c: // image descriptor, LZW code size then sub-blocks
+			desc := make([]byte, 10)
+			if _, err := stdio.ReadFull(r, desc); err != nil ||
+				skipColorTable(r, desc[8]) != nil {
+				return 0
+			}
+			frames++
+		case 0x3b: // trailer
+			return frames
+		default:
+			return 0
+		}
+		if err := skipSubBlocks(r); err != nil {
+			return 0
+		}
+	}
+}
+
+func skipColorTable(r *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := r.Discard(3 << (flags&0x07 + 1))
+	return err
+}
+
+func skipSubBlocks(r *bufio.Reader) error {
+	for {
+		n, err := r.ReadByte()
+		if err != nil || n == 0 {
+			return err
+		}
+		if _, err := r.Discard(int(n)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *summary) write(w stdio.Writer, code int) {
+	if s == nil {
+		return
+	}
+	s.ExitCode = code
+	s.Ok = code == 0
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s)
+}
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -36,16 +37,89 @@ type Arguments struct {
 	Version bool
 	DryRun  bool
 	Verbose bool
+	Json    bool
 	VideoIn string
 	Port    int
+	Ffmpeg  string
+	Ffprobe string
+	WorkDir string
+	LogOptions
+
+	// Largest video read from stdin or downloaded, in MB
+	MaxInput int64
 
 	NeedScaling bool
 	ScaleFilter string
 	Fps         int
 	SpeedSpec   string
 
-	From   TimeCode
-	Length time.Duration
+	From     TimeCode
+	FromNow  bool // -from now, for growing files
+	Length   time.Duration
+	AutoClip bool
+
+	// -from-frame, -frames & -to-frame, converted into From &
+	// Length by ResolveFrames once the frame rate is known.
+	FromFrame int64
+	Frames    int64
+	ToFrame   int64
+
+	Sheet       bool
+	SheetCols   int
+	SheetRows   int
+	SheetLabels bool
+	SheetFormat string
+
+	Dedup          bool
+	DedupTolerance float64
+
+	// ffmpegs extracting segments of the frames at the same time
+	Parallel int
+
+	// GIF straight from the frames, without the x264 intermediate
+	Direct bool
+
+	// frames go through a pipe instead of PNGs on disk
+	Stream bool
+
+	// the end of the clip fades into its start over this long
+	SmoothLoop time.Duration
+
+	// the clip ends, and with AutoLoopStart also starts, where it
+	// loops best within this much of -length
+	AutoLoop      time.Duration
+	AutoLoopStart bool
+
+	// clockwise degrees on top of the rotation the video is
+	// tagged with, which NoAutorotate ignores. Flip is h or v.
+	Rotate       int
+	Flip         string
+	NoAutorotate bool
+
+	// PNG overlaid on every frame after -scale, WatermarkScale
+	// times the width of the GIF
+	Watermark         string
+	WatermarkPosition string
+	WatermarkMargin   int
+	WatermarkScale    float64
+	WatermarkOpacity  float64
+
+	// a quick GIF of the window first, see PreviewArguments
+	Preview       bool
+	PreviewInline bool
+
+	// GIF palette, see UsePalette
+	Colors          int
+	Dither          string
+	BayerScale      int
+	PaletteStats    string
+	PalettePerFrame bool
+
+	// Identifies the job in progress reports. Empty for the cli.
+	Job string
+
+	// names of flags explicitly given on the command line
+	given map[string]bool
 }
 
 func NewArguments() *Arguments {
@@ -61,8 +135,14 @@ func (a *Arguments) Parse(arguments []string) error {
 	f.BoolVar(&a.Version, "version", false, "")
 	f.BoolVar(&a.DryRun, "dry-run", false, "")
 	f.BoolVar(&a.Verbose, "vv", false, "")
+	f.BoolVar(&a.Json, "json", false, "")
 	f.StringVar(&a.VideoIn, "video-infile", a.VideoIn, "")
 	f.IntVar(&a.Port, "port", 8080, "")
+	f.StringVar(&a.Ffmpeg, "ffmpeg", "", "")
+	f.StringVar(&a.Ffprobe, "ffprobe", "", "")
+	f.StringVar(&a.WorkDir, "workdir", "", "")
+	f.Int64Var(&a.MaxInput, "max-input", 1024, "")
+	a.LogOptions.flags(f)
 
 	scalingArg := f.String("scale", "_:_", "")
 	speedArg := f.String("speed", "placebo", "")
@@ -70,11 +150,46 @@ func (a *Arguments) Parse(arguments []string) error {
 
 	f.DurationVar(&a.Length, "length", 3*time.Second, "")
 	fromArg := f.String("from", "00:00:00", "")
+	f.BoolVar(&a.AutoClip, "auto-clip", false, "")
+	f.Int64Var(&a.FromFrame, "from-frame", 0, "")
+	f.Int64Var(&a.Frames, "frames", 0, "")
+	f.Int64Var(&a.ToFrame, "to-frame", 0, "")
+
+	sheetArg := f.String("sheet", "", "")
+	f.BoolVar(&a.SheetLabels, "sheet-labels", false, "")
+	f.StringVar(&a.SheetFormat, "sheet-format", "png", "")
+
+	f.BoolVar(&a.Dedup, "dedup", false, "")
+	f.Float64Var(&a.DedupTolerance, "dedup-tolerance", 0.0, "")
+	f.IntVar(&a.Parallel, "parallel", runtime.NumCPU(), "")
+	f.BoolVar(&a.Direct, "direct", false, "")
+	f.BoolVar(&a.Stream, "stream", false, "")
+	f.DurationVar(&a.SmoothLoop, "smooth-loop", 0, "")
+	f.DurationVar(&a.AutoLoop, "auto-loop", 0, "")
+	f.BoolVar(&a.AutoLoopStart, "auto-loop-start", false, "")
+	f.IntVar(&a.Rotate, "rotate", 0, "")
+	f.StringVar(&a.Flip, "flip", "", "")
+	f.BoolVar(&a.NoAutorotate, "no-autorotate", false, "")
+	f.StringVar(&a.Watermark, "watermark", "", "")
+	f.StringVar(&a.WatermarkPosition, "watermark-position", "bottom-right", "")
+	f.IntVar(&a.WatermarkMargin, "watermark-margin", 10, "")
+	f.Float64Var(&a.WatermarkScale, "watermark-scale", 0.15, "")
+	f.Float64Var(&a.WatermarkOpacity, "watermark-opacity", 1.0, "")
+	f.BoolVar(&a.Preview, "preview", false, "")
+	f.BoolVar(&a.PreviewInline, "preview-inline", false, "")
+
+	f.IntVar(&a.Colors, "colors", 256, "")
+	ditherArg := f.String("dither", "sierra", "")
+	f.StringVar(&a.PaletteStats, "palette-stats", "full", "")
+	f.BoolVar(&a.PalettePerFrame, "palette-per-frame", false, "")
 
 	if err := f.Parse(arguments); err != nil {
 		return err
 	}
 
+	a.given = make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) { a.given[fl.Name] = true })
+
 	if err := preprocessScale(a, *scalingArg); err != nil {
 		return err
 	}
@@ -84,27 +199,269 @@ func (a *Arguments) Parse(arguments []string) error {
 	if err := preprocessFrom(a, *fromArg); err != nil {
 		return err
 	}
+	if err := preprocessSheet(a, *sheetArg); err != nil {
+		return err
+	}
+	if err := preprocessDither(a, *ditherArg); err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// Whether the named flag was explicitly supplied to Parse
+func (a *Arguments) IsSet(name string) bool {
+	return a.given[name]
+}
+
+// Whether the clip is selected by frame numbers
+func (a *Arguments) ByFrame() bool {
+	return a.IsSet("from-frame") || a.IsSet("frames") || a.IsSet("to-frame")
+}
+
+// Converts the frame numbers into From & Length. -to-frame is
+// inclusive. Without -frames or -to-frame, Length is kept.
+func (a *Arguments) ResolveFrames(rate Rational) error {
+	if !a.ByFrame() {
+		return nil
+	}
+	if !rate.Valid() {
+		return errors.New("frame rate of the video is unknown, " +
+			"use -from & -length instead of frame numbers")
+	}
+	start := rate.FrameOffset(a.FromFrame)
+	a.From = NewTimeCode(start)
+	switch {
+	case a.IsSet("frames"):
+		a.Length = rate.FrameOffset(a.FromFrame+a.Frames) - start
+	case a.IsSet("to-frame"):
+		a.Length = rate.FrameOffset(a.ToFrame+1) - start
+	}
+	return a.validateOverscan(true)
+}
+
+// How much FrameGenerator extracts past -length for the stages
+// after it: -smooth-loop fades it in, -auto-loop searches it
+func (a *Arguments) Overscan() time.Duration {
+	return a.SmoothLoop + a.AutoLoop
+}
+
+// Neither may reach further than -length, which is only known
+// once resolved when the window is given by frame numbers
+func (a *Arguments) validateOverscan(resolved bool) error {
+	for _, o := range []struct {
+		name string
+		d    time.Duration
+	}{{"smooth-loop", a.SmoothLoop}, {"auto-loop", a.AutoLoop}} {
+		if o.d < 0 || (resolved && o.d > a.Length) {
+			return fmt.Errorf("-%s %s not in range [0, -length]",
+				o.name, o.d)
+		}
+	}
+	return nil
+}
+
+const (
+	PREVIEW_FPS   = 5
+	PREVIEW_WIDTH = 160
+)
+
+// A copy for a small GIF of the same window at no more than
+// PREVIEW_FPS, made as fast as possible
+func (a *Arguments) PreviewArguments() *Arguments {
+	p := *a
+	if p.Fps > PREVIEW_FPS {
+		p.Fps = PREVIEW_FPS
+	}
+	p.ScaleFilter, _ = WidthOnly.Decode(PREVIEW_WIDTH)
+	p.NeedScaling = true
+	p.Stream, p.Direct, p.Dedup, p.Preview = true, true, false, false
+	p.SmoothLoop, p.AutoLoop, p.AutoLoopStart = 0, 0, false
+	return &p
+}
+
+// Whether the GIF gets a generated palette instead of the
+// fixed one of the gif encoder.
+func (a *Arguments) UsePalette() bool {
+	for _, name := range []string{"colors", "dither", "palette-stats",
+		"palette-per-frame"} {
+		if a.IsSet(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Where ffmpeg posts its -progress reports
+func (a *Arguments) ProgressUrl() string {
+	return fmt.Sprintf("http://127.0.0.1:%d/%s", a.Port, a.Job)
+}
+
 func (a *Arguments) Validate() error {
-	if _, err := SanitizeFile(a.VideoIn); err != nil {
+	if err := ValidateInput(a.VideoIn); err != nil {
 		return err
 	}
 
+	if a.MaxInput < 1 {
+		return fmt.Errorf("-max-input %d must be at least 1 (MB)", a.MaxInput)
+	}
+
 	if err := ValidatePort(a.Port); err != nil {
 		return err
 	}
 
+	if err := a.LogOptions.validate(); err != nil {
+		return err
+	}
+
 	if a.Fps < 1 || a.Fps > 30 {
 		return fmt.Errorf("frame rate -fps %d not in range [1, 30]", a.Fps)
 	}
 
+	if a.AutoClip && (a.From.Offset() > 0 || a.FromNow) {
+		return errors.New("-auto-clip cannot be combined with -from")
+	}
+
+	if err := a.validateFrames(); err != nil {
+		return err
+	}
+
+	if a.FromNow && (IsStdin(a.VideoIn) || IsUrl(a.VideoIn)) {
+		return errors.New("-from now needs a local file that is growing")
+	}
+
+	if a.DedupTolerance < 0.0 || a.DedupTolerance >= 100.0 {
+		return fmt.Errorf("-dedup-tolerance %g not in range [0, 100)",
+			a.DedupTolerance)
+	}
+
+	if a.Parallel < 1 {
+		return fmt.Errorf("-parallel %d must be at least 1", a.Parallel)
+	}
+
+	if a.Colors < 4 || a.Colors > 256 {
+		return fmt.Errorf("-colors %d not in range [4, 256]", a.Colors)
+	}
+
+	if a.PaletteStats != "full" && a.PaletteStats != "diff" {
+		return fmt.Errorf("-palette-stats %q is not full or diff",
+			a.PaletteStats)
+	}
+
+	if a.PalettePerFrame && a.IsSet("palette-stats") {
+		return errors.New("-palette-per-frame cannot be combined with -palette-stats")
+	}
+
+	if a.Preview && (a.Sheet || a.FromNow) {
+		return errors.New("-preview cannot be combined with -sheet or -from now")
+	}
+
+	if a.Stream && (a.Dedup || a.Sheet || a.Overscan() > 0) {
+		return errors.New("-stream cannot be combined with -dedup, -sheet, " +
+			"-smooth-loop or -auto-loop")
+	}
+
+	if a.AutoLoop > 0 && a.SmoothLoop > 0 {
+		return errors.New("-auto-loop cannot be combined with -smooth-loop")
+	}
+	if a.AutoLoopStart && a.AutoLoop == 0 {
+		return errors.New("-auto-loop-start needs -auto-loop")
+	}
+	if err := a.validateOverscan(!a.ByFrame()); err != nil {
+		return err
+	}
+
+	if a.Sheet {
+		if _, ok := sheetFormats[a.SheetFormat]; !ok {
+			return fmt.Errorf("-sheet-format %q is not png or jpg",
+				a.SheetFormat)
+		}
+	}
+
+	switch a.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("-rotate %d is not 90, 180 or 270", a.Rotate)
+	}
+	if a.Flip != "" && a.Flip != "h" && a.Flip != "v" {
+		return fmt.Errorf("-flip %q is not h or v", a.Flip)
+	}
+
+	return a.validateWatermark()
+}
+
+func (a *Arguments) validateWatermark() error {
+	if IsEmpty(a.Watermark) {
+		for _, name := range []string{"watermark-position",
+			"watermark-margin", "watermark-scale", "watermark-opacity"} {
+			if a.IsSet(name) {
+				return fmt.Errorf("-%s needs -watermark", name)
+			}
+		}
+		return nil
+	}
+	file, err := SanitizePng(a.Watermark)
+	if err != nil {
+		return fmt.Errorf("-watermark %q: %v", a.Watermark, err)
+	}
+	a.Watermark = file
+
+	switch {
+	case a.Sheet:
+		return errors.New("-watermark cannot be combined with -sheet")
+	case !isWatermarkPosition(a.WatermarkPosition):
+		return fmt.Errorf("-watermark-position %q is not top-left, "+
+			"top-right, bottom-left or bottom-right", a.WatermarkPosition)
+	case a.WatermarkMargin < 0:
+		return fmt.Errorf("-watermark-margin %d must not be negative",
+			a.WatermarkMargin)
+	case a.WatermarkScale <= 0 || a.WatermarkScale > 1:
+		return fmt.Errorf("-watermark-scale %g not in range (0, 1]",
+			a.WatermarkScale)
+	case a.WatermarkOpacity <= 0 || a.WatermarkOpacity > 1:
+		return fmt.Errorf("-watermark-opacity %g not in range (0, 1]",
+			a.WatermarkOpacity)
+	}
+	return nil
+}
+
+func isWatermarkPosition(position string) bool {
+	switch position {
+	case "top-left", "top-right", "bottom-left", "bottom-right":
+		return true
+	}
+	return false
+}
+
+func (a *Arguments) validateFrames() error {
+	if !a.ByFrame() {
+		return nil
+	}
+	for _, name := range []string{"from", "length", "auto-clip"} {
+		if a.IsSet(name) {
+			return fmt.Errorf("-%s cannot be combined with frame numbers",
+				name)
+		}
+	}
+	switch {
+	case a.IsSet("frames") && a.IsSet("to-frame"):
+		return errors.New("-frames cannot be combined with -to-frame")
+	case a.FromFrame < 0:
+		return fmt.Errorf("-from-frame %d must not be negative", a.FromFrame)
+	case a.IsSet("frames") && a.Frames < 1:
+		return fmt.Errorf("-frames %d must be at least 1", a.Frames)
+	case a.IsSet("to-frame") && a.ToFrame < a.FromFrame:
+		return fmt.Errorf("-to-frame %d is before -from-frame %d",
+			a.ToFrame, a.FromFrame)
+	}
 	return nil
 }
 
 func preprocessFrom(a *Arguments, fromArg string) error {
+	if fromArg == "now" {
+		a.FromNow = true
+		return nil
+	}
 	if fromArg != "00:00:00" {
 		tc, err := ParseFrom(fromArg)
 		if err != nil {
@@ -115,6 +472,73 @@ func preprocessFrom(a *Arguments, fromArg string) error {
 	return nil
 }
 
+var rgxSheet = regexp.MustCompile(`^(?P<cols>\d{1,2})x(?P<rows>\d{1,2})$`)
+
+var sheetFormats = map[string]struct{}{
+	"png":  empty,
+	"jpg":  empty,
+	"jpeg": empty,
+}
+
+// -sheet 4x3 : contact sheet of 4 columns and 3 rows
+func preprocessSheet(a *Arguments, sheetArg string) error {
+	if sheetArg == "" {
+		return nil
+	}
+	if !rgxSheet.MatchString(sheetArg) {
+		return fmt.Errorf("BAD arg to -sheet %q", sheetArg)
+	}
+	cols, _ := strconv.Atoi(rgxSheet.ReplaceAllString(sheetArg,
+		fmt.Sprintf("${%s}", rgxSheet.SubexpNames()[1])))
+	rows, _ := strconv.Atoi(rgxSheet.ReplaceAllString(sheetArg,
+		fmt.Sprintf("${%s}", rgxSheet.SubexpNames()[2])))
+	if cols < 1 || cols > 16 || rows < 1 || rows > 16 {
+		return fmt.Errorf("-sheet %q: columns & rows must be in range [1, 16]",
+			sheetArg)
+	}
+	a.Sheet = true
+	a.SheetCols = cols
+	a.SheetRows = rows
+	return nil
+}
+
+// ffmpeg's paletteuse names, sierra being its default sierra2_4a
+var dithers = map[string]string{
+	"none":            "none",
+	"bayer":           "bayer",
+	"floyd_steinberg": "floyd_steinberg",
+	"sierra":          "sierra2_4a",
+}
+
+var rgxDither = regexp.MustCompile(`^(?P<name>[a-z_]+)(:(?P<scale>\d))?$`)
+
+// -dither bayer:3 : ordered dithering, crosshatch grows with the scale
+func preprocessDither(a *Arguments, ditherArg string) error {
+	m := rgxDither.FindStringSubmatch(ditherArg)
+	if m == nil {
+		return fmt.Errorf("BAD arg to -dither %q", ditherArg)
+	}
+	dither, ok := dithers[m[1]]
+	if !ok {
+		return fmt.Errorf("-dither %q is not none, bayer, floyd_steinberg "+
+			"or sierra", ditherArg)
+	}
+	a.Dither = dither
+	a.BayerScale = 2
+	if m[3] != "" {
+		if dither != "bayer" {
+			return fmt.Errorf("-dither %q: only bayer takes a scale",
+				ditherArg)
+		}
+		a.BayerScale, _ = strconv.Atoi(m[3])
+		if a.BayerScale > 5 {
+			return fmt.Errorf("-dither %q: scale must be in range [0, 5]",
+				ditherArg)
+		}
+	}
+	return nil
+}
+
 var rgxScale = regexp.MustCompile(`^(?P<width>(_|\d{1,})):(?P<height>(_|\d{1,}))$`)
 
 var isUnderscore = func(arg string) bool {
@@ -221,8 +645,28 @@ const (
 
 type TimeCode time.Time
 
+// Converts an offset from the start of the video into a TimeCode
+func NewTimeCode(offset time.Duration) TimeCode {
+	var zero time.Time
+	return TimeCode(zero.Add(offset))
+}
+
+// Offset from the start of the video
+func (tc TimeCode) Offset() time.Duration {
+	t := time.Time(tc)
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second +
+		time.Duration(t.Nanosecond())
+}
+
+// Milliseconds are only shown when present e.g. 00:01:04.250
 func (tc TimeCode) String() string {
 	t := time.Time(tc)
+	if ms := t.Nanosecond() / int(time.Millisecond); ms > 0 {
+		return fmt.Sprintf("%0.2d:%0.2d:%0.2d.%0.3d",
+			t.Hour(), t.Minute(), t.Second(), ms)
+	}
 	return fmt.Sprintf("%0.2d:%0.2d:%0.2d",
 		t.Hour(), t.Minute(), t.Second())
 }
@@ -297,15 +741,17 @@ var scales = map[ScaleType]struct{}{
 	WidthHeight: empty,
 }
 
-// Converts into a valid argument to the -vf option of ffmpeg
+// Converts into a valid argument to the -vf option of ffmpeg.
+// The aspect kept is the display one (dar), the pixels of the
+// result square, so anamorphic videos are not squashed.
 func (s ScaleType) interpolate(width, height uint16) string {
 	switch s {
 	case WidthHeight:
-		return fmt.Sprintf("scale=%d:%d", width, height)
+		return fmt.Sprintf("scale=%d:%d,setsar=1", width, height)
 	case HeightOnly:
-		return fmt.Sprintf("scale=trunc(oh*a/2)*2:%d", height)
+		return fmt.Sprintf("scale=trunc(oh*dar/2)*2:%d,setsar=1", height)
 	case WidthOnly:
-		return fmt.Sprintf("scale=%d:trunc(ow/a/2)*2", width)
+		return fmt.Sprintf("scale=%d:trunc(ow/dar/2)*2,setsar=1", width)
 	default:
 		return ""
 	}
@@ -18,9 +18,15 @@ License.
 package util
 
 import (
-	"github.com/stretchr/testify/assert"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 var speedFixtures = []struct {
@@ -79,17 +85,17 @@ func TestPreprocessingScale(t *testing.T) {
 	a := NewArguments()
 	assert.NoError(t, preprocessScale(a, "_:600"))
 	assert.Equal(t, a.NeedScaling, true)
-	assert.Equal(t, a.ScaleFilter, "scale=trunc(oh*a/2)*2:600")
+	assert.Equal(t, a.ScaleFilter, "scale=trunc(oh*dar/2)*2:600,setsar=1")
 
 	a = NewArguments()
 	assert.NoError(t, preprocessScale(a, "300:600"))
 	assert.Equal(t, a.NeedScaling, true)
-	assert.Equal(t, a.ScaleFilter, "scale=300:600")
+	assert.Equal(t, a.ScaleFilter, "scale=300:600,setsar=1")
 
 	a = NewArguments()
 	assert.NoError(t, preprocessScale(a, "300:_"))
 	assert.Equal(t, a.NeedScaling, true)
-	assert.Equal(t, a.ScaleFilter, "scale=300:trunc(ow/a/2)*2")
+	assert.Equal(t, a.ScaleFilter, "scale=300:trunc(ow/dar/2)*2,setsar=1")
 }
 
 func TestScaleType(t *testing.T) {
@@ -115,7 +121,7 @@ func TestScaleType(t *testing.T) {
 	}
 
 	a, _ := WidthOnly.Decode(100)
-	assert.Equal(t, a, "scale=100:trunc(ow/a/2)*2")
+	assert.Equal(t, a, "scale=100:trunc(ow/dar/2)*2,setsar=1")
 
 	_, err = WidthOnly.Decode(101)
 	if assert.Error(t, err, "An error was expected") {
@@ -128,7 +134,7 @@ func TestScaleType(t *testing.T) {
 	}
 
 	a, _ = HeightOnly.Decode(666)
-	assert.Equal(t, a, "scale=trunc(oh*a/2)*2:666")
+	assert.Equal(t, a, "scale=trunc(oh*dar/2)*2:666,setsar=1")
 
 	_, err = HeightOnly.Decode(661)
 	if assert.Error(t, err, "An error was expected") {
@@ -146,7 +152,7 @@ func TestScaleType(t *testing.T) {
 	}
 
 	a, _ = WidthHeight.Decode(640, 480)
-	assert.Equal(t, a, "scale=640:480")
+	assert.Equal(t, a, "scale=640:480,setsar=1")
 
 	_, err = WidthHeight.Decode(641, 480)
 	if assert.Error(t, err, "An error was expected") {
@@ -159,5 +165,249 @@ func TestScaleType(t *testing.T) {
 	}
 
 	a, _ = WidthHeight.Decode(1280, 760, 481, 211)
-	assert.Equal(t, a, "scale=1280:760")
+	assert.Equal(t, a, "scale=1280:760,setsar=1")
+}
+
+func TestTimeCode(t *testing.T) {
+	tc := NewTimeCode(time.Hour + 4*time.Second + 250*time.Millisecond)
+	assert.Equal(t, "01:00:04.250", tc.String())
+	assert.Equal(t, time.Hour+4*time.Second+250*time.Millisecond, tc.Offset())
+
+	from, err := ParseFrom("00:01:04")
+	assert.NoError(t, err)
+	assert.Equal(t, 64*time.Second, from.Offset())
+	assert.Equal(t, "00:01:04", from.String())
+}
+
+func TestPreprocessDither(t *testing.T) {
+	a := NewArguments()
+	assert.NoError(t, preprocessDither(a, "sierra"))
+	assert.Equal(t, "sierra2_4a", a.Dither)
+	assert.NoError(t, preprocessDither(a, "bayer:5"))
+	assert.Equal(t, "bayer", a.Dither)
+	assert.Equal(t, 5, a.BayerScale)
+
+	for _, bad := range []string{"", "bayer:6", "sierra:2", "atkinson",
+		"bayer:"} {
+		assert.Error(t, preprocessDither(a, bad), bad)
+	}
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-colors", "2"}))
+	assert.Error(t, a.Validate())
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-palette-per-frame", "-palette-stats", "diff"}))
+	assert.Error(t, a.Validate())
+}
+
+func TestResolveFrames(t *testing.T) {
+	ntsc := Rational{30000, 1001}
+	a := NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-from-frame", "60", "-frames", "30"}))
+	assert.NoError(t, a.Validate())
+	assert.True(t, a.ByFrame())
+	assert.NoError(t, a.ResolveFrames(ntsc))
+	assert.Equal(t, "00:00:02.002", a.From.String())
+	assert.Equal(t, 1001*time.Millisecond, a.Length)
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-from-frame", "60", "-to-frame", "89"}))
+	assert.NoError(t, a.ResolveFrames(ntsc))
+	assert.Equal(t, 1001*time.Millisecond, a.Length)
+	assert.Error(t, a.ResolveFrames(Rational{}))
+
+	for _, bad := range [][]string{
+		{"-from-frame", "10", "-from", "00:00:01"},
+		{"-frames", "10", "-length", "2s"},
+		{"-frames", "10", "-auto-clip"},
+		{"-frames", "10", "-to-frame", "20"},
+		{"-frames", "0"},
+		{"-from-frame", "-1"},
+		{"-from-frame", "20", "-to-frame", "10"},
+	} {
+		a = NewArguments()
+		assert.NoError(t, a.Parse(append([]string{"-video-infile",
+			"args.go"}, bad...)))
+		assert.Error(t, a.Validate(), "%v", bad)
+	}
+}
+
+func TestOrientValidate(t *testing.T) {
+	a := NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-rotate", "270", "-flip", "v", "-no-autorotate"}))
+	assert.NoError(t, a.Validate())
+
+	for _, bad := range [][]string{
+		{"-rotate", "45"},
+		{"-rotate", "-90"},
+		{"-flip", "x"},
+	} {
+		a = NewArguments()
+		assert.NoError(t, a.Parse(append([]string{"-video-infile",
+			"args.go"}, bad...)))
+		assert.Error(t, a.Validate(), "%v", bad)
+	}
+}
+
+func TestWatermarkValidate(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "seneca-watermark")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	logo := filepath.Join(tmp, "logo.png")
+	fh, err := os.Create(logo)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(fh, image.NewRGBA(image.Rect(0, 0, 8, 4))))
+	fh.Close()
+
+	a := NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-watermark", tmp + "/./logo.png", "-watermark-position", "top-left",
+		"-watermark-opacity", "0.5"}))
+	assert.NoError(t, a.Validate())
+	assert.Equal(t, logo, a.Watermark)
+
+	for _, bad := range [][]string{
+		{"-watermark", "args.go"},
+		{"-watermark", tmp},
+		{"-watermark", logo, "-sheet", "2x2"},
+		{"-watermark", logo, "-watermark-position", "middle"},
+		{"-watermark", logo, "-watermark-margin", "-1"},
+		{"-watermark", logo, "-watermark-scale", "0"},
+		{"-watermark", logo, "-watermark-opacity", "1.5"},
+		{"-watermark-scale", "0.5"},
+	} {
+		a = NewArguments()
+		assert.NoError(t, a.Parse(append([]string{"-video-infile",
+			"args.go"}, bad...)))
+		assert.Error(t, a.Validate(), "%v", bad)
+	}
+}
+
+func TestSmoothLoopValidate(t *testing.T) {
+	a := NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-length", "2s", "-smooth-loop", "500ms"}))
+	assert.NoError(t, a.Validate())
+	assert.Equal(t, time.Duration(0), a.PreviewArguments().SmoothLoop)
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-auto-loop", "1s", "-auto-loop-start"}))
+	assert.NoError(t, a.Validate())
+	assert.Equal(t, time.Second, a.Overscan())
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-from-frame", "0", "-frames", "30",
+		"-smooth-loop", "2s"}))
+	assert.Error(t, a.ResolveFrames(Rational{30, 1}))
+
+	for _, bad := range [][]string{
+		{"-length", "2s", "-smooth-loop", "3s"},
+		{"-smooth-loop", "-1s"},
+		{"-smooth-loop", "1s", "-stream"},
+		{"-auto-loop", "1s", "-stream"},
+		{"-auto-loop", "1s", "-smooth-loop", "1s"},
+		{"-auto-loop", "4s"},
+		{"-auto-loop-start"},
+	} {
+		a = NewArguments()
+		assert.NoError(t, a.Parse(append([]string{"-video-infile",
+			"args.go"}, bad...)))
+		assert.Error(t, a.Validate(), "%v", bad)
+	}
+}
+
+func TestAutoClipValidate(t *testing.T) {
+	a := NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-auto-clip"}))
+	assert.True(t, a.AutoClip)
+	assert.NoError(t, a.Validate())
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-auto-clip", "-from", "00:00:10"}))
+	assert.Error(t, a.Validate())
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-auto-clip", "-from", "now"}))
+	assert.Error(t, a.Validate())
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "-",
+		"-from", "now"}))
+	assert.Error(t, a.Validate())
+}
+
+func TestSceneArguments(t *testing.T) {
+	a := NewSceneArguments()
+	assert.Error(t, a.Parse([]string{}))
+
+	a = NewSceneArguments()
+	assert.NoError(t, a.Parse([]string{"-threshold", "0.4", "args.go"}))
+	assert.Equal(t, "args.go", a.VideoIn)
+	assert.Equal(t, 0.4, a.Threshold)
+	assert.NoError(t, a.Validate())
+
+	a = NewSceneArguments()
+	assert.NoError(t, a.Parse([]string{"-threshold", "1.5", "args.go"}))
+	assert.Error(t, a.Validate())
+}
+
+func TestPreprocessSheet(t *testing.T) {
+	a := NewArguments()
+	assert.NoError(t, preprocessSheet(a, ""))
+	assert.False(t, a.Sheet)
+
+	assert.NoError(t, preprocessSheet(a, "4x3"))
+	assert.True(t, a.Sheet)
+	assert.Equal(t, 4, a.SheetCols)
+	assert.Equal(t, 3, a.SheetRows)
+
+	assert.Error(t, preprocessSheet(NewArguments(), "4x"))
+	assert.Error(t, preprocessSheet(NewArguments(), "0x3"))
+	assert.Error(t, preprocessSheet(NewArguments(), "17x3"))
+
+	a = NewArguments()
+	assert.NoError(t, a.Parse([]string{"-video-infile", "args.go",
+		"-sheet", "2x2", "-sheet-format", "gif"}))
+	assert.Error(t, a.Validate())
+	assert.False(t, a.IsSet("length"))
+	assert.True(t, a.IsSet("sheet"))
+}
+
+func TestOptionArgs(t *testing.T) {
+	argv, err := OptionArgs(map[string]interface{}{
+		"length": "5s", "fps": float64(10), "dedup": true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-dedup=true", "-fps=10", "-length=5s"}, argv)
+
+	a := NewArguments()
+	assert.NoError(t, a.Parse(argv))
+	assert.Equal(t, 10, a.Fps)
+	assert.True(t, a.Dedup)
+
+	// JSON numbers are float64, large ones must not become 1e+06
+	argv, err = OptionArgs(map[string]interface{}{
+		"from-frame": float64(1000000), "dedup-tolerance": 0.5})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-dedup-tolerance=0.5", "-from-frame=1000000"}, argv)
+	a = NewArguments()
+	assert.NoError(t, a.Parse(argv))
+	assert.Equal(t, int64(1000000), a.FromFrame)
+
+	for _, name := range []string{"port", "dry-run", "preview",
+		"preview-inline", "vv", "max-input"} {
+		_, err = OptionArgs(map[string]interface{}{name: float64(1)})
+		assert.Error(t, err, name)
+	}
+
+	_, err = OptionArgs(map[string]interface{}{"scale": []interface{}{1, 2}})
+	assert.Error(t, err)
 }
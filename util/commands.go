@@ -0,0 +1,278 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package util
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Options that belong to the process rather than to one video
+var reserved = map[string]struct{}{
+	"h":            empty,
+	"version":      empty,
+	"json":         empty,
+	"video-infile": empty,
+	"port":         empty,
+	"ffmpeg":       empty,
+	"ffprobe":      empty,
+	"workdir":      empty,
+	"log-level":    empty,
+	"log-format":   empty,
+	// interactive or local to the machine of the cli
+	"dry-run":        empty,
+	"preview":        empty,
+	"preview-inline": empty,
+	"vv":             empty,
+	"max-input":      empty,
+}
+
+// Turns {"length": "5s", "dedup": true} into -dedup=true -length=5s
+// for Arguments.Parse. Used by the server & the watch presets.
+func OptionArgs(options map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		if _, ok := reserved[k]; ok {
+			return nil, fmt.Errorf("option %q cannot be set per video", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	argv := make([]string, 0, len(keys))
+	for _, k := range keys {
+		switch v := options[k].(type) {
+		case string, bool:
+			argv = append(argv, fmt.Sprintf("-%s=%v", k, v))
+		case float64:
+			// never 1e+06, which the flags cannot parse
+			argv = append(argv, fmt.Sprintf("-%s=%s", k,
+				strconv.FormatFloat(v, 'f', -1, 64)))
+		default:
+			return nil, fmt.Errorf("option %q has unsupported value %v", k, v)
+		}
+	}
+	return argv, nil
+}
+
+// Arguments of `seneca scenes [options] <video>`
+type SceneArguments struct {
+	DryRun    bool
+	Verbose   bool
+	VideoIn   string
+	Threshold float64
+	Ffmpeg    string
+	Ffprobe   string
+	LogOptions
+}
+
+func NewSceneArguments() *SceneArguments {
+	args := new(SceneArguments)
+	return args
+}
+
+func (a *SceneArguments) Parse(arguments []string) error {
+	f := flag.NewFlagSet("seneca scenes", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+
+	f.BoolVar(&a.DryRun, "dry-run", false, "")
+	f.BoolVar(&a.Verbose, "vv", false, "")
+	f.Float64Var(&a.Threshold, "threshold", 0.3, "")
+	f.StringVar(&a.Ffmpeg, "ffmpeg", "", "")
+	f.StringVar(&a.Ffprobe, "ffprobe", "", "")
+	a.LogOptions.flags(f)
+
+	if err := f.Parse(arguments); err != nil {
+		return err
+	}
+
+	if f.NArg() != 1 {
+		return errors.New("scenes expects exactly one <video>")
+	}
+	a.VideoIn = f.Arg(0)
+	return nil
+}
+
+func (a *SceneArguments) Validate() error {
+	if _, err := SanitizeFile(a.VideoIn); err != nil {
+		return err
+	}
+
+	if a.Threshold <= 0.0 || a.Threshold >= 1.0 {
+		return fmt.Errorf("-threshold %g not in range (0, 1)", a.Threshold)
+	}
+	return a.LogOptions.validate()
+}
+
+// Arguments of `seneca serve [options]`
+type ServeArguments struct {
+	Verbose   bool
+	Listen    string
+	Port      int
+	QueueSize int
+	Workers   int
+	WorkDir   string
+	MaxUpload int64
+	Ffmpeg    string
+	Ffprobe   string
+	LogOptions
+}
+
+func NewServeArguments() *ServeArguments {
+	args := new(ServeArguments)
+	return args
+}
+
+func (a *ServeArguments) Parse(arguments []string) error {
+	f := flag.NewFlagSet("seneca serve", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+
+	f.BoolVar(&a.Verbose, "vv", false, "")
+	f.StringVar(&a.Listen, "listen", ":8090", "")
+	f.IntVar(&a.Port, "port", 8080, "")
+	f.IntVar(&a.QueueSize, "queue", 16, "")
+	f.IntVar(&a.Workers, "workers", 1, "")
+	f.StringVar(&a.WorkDir, "workdir", "", "")
+	f.Int64Var(&a.MaxUpload, "max-upload", 512, "")
+	f.StringVar(&a.Ffmpeg, "ffmpeg", "", "")
+	f.StringVar(&a.Ffprobe, "ffprobe", "", "")
+	a.LogOptions.flags(f)
+
+	if err := f.Parse(arguments); err != nil {
+		return err
+	}
+	if f.NArg() != 0 {
+		return fmt.Errorf("serve takes no arguments, got %q", f.Args())
+	}
+	return nil
+}
+
+func (a *ServeArguments) Validate() error {
+	if err := ValidatePort(a.Port); err != nil {
+		return err
+	}
+	if a.QueueSize < 1 {
+		return fmt.Errorf("-queue %d must be at least 1", a.QueueSize)
+	}
+	if a.Workers < 1 {
+		return fmt.Errorf("-workers %d must be at least 1", a.Workers)
+	}
+	if a.MaxUpload < 1 {
+		return fmt.Errorf("-max-upload %d must be at least 1 (MB)", a.MaxUpload)
+	}
+	return a.LogOptions.validate()
+}
+
+// Arguments of `seneca watch [options] <dir>`
+type WatchArguments struct {
+	Verbose bool
+	Once    bool
+	Dir     string
+	Config  string
+	Output  string
+	Errors  string
+	Port    int
+	Ffmpeg  string
+	Ffprobe string
+	LogOptions
+}
+
+func NewWatchArguments() *WatchArguments {
+	args := new(WatchArguments)
+	return args
+}
+
+func (a *WatchArguments) Parse(arguments []string) error {
+	f := flag.NewFlagSet("seneca watch", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+
+	f.BoolVar(&a.Verbose, "vv", false, "")
+	f.BoolVar(&a.Once, "once", false, "")
+	f.StringVar(&a.Config, "config", "", "")
+	f.StringVar(&a.Output, "output", "", "")
+	f.StringVar(&a.Errors, "errors", "", "")
+	f.IntVar(&a.Port, "port", 8080, "")
+	f.StringVar(&a.Ffmpeg, "ffmpeg", "", "")
+	f.StringVar(&a.Ffprobe, "ffprobe", "", "")
+	a.LogOptions.flags(f)
+
+	if err := f.Parse(arguments); err != nil {
+		return err
+	}
+
+	if f.NArg() != 1 {
+		return errors.New("watch expects exactly one <dir>")
+	}
+	a.Dir = f.Arg(0)
+	return nil
+}
+
+func (a *WatchArguments) Validate() error {
+	if IsEmpty(a.Dir) {
+		return InvalidPath
+	}
+	if err := ValidatePort(a.Port); err != nil {
+		return err
+	}
+	return a.LogOptions.validate()
+}
+
+// Arguments of `seneca gc [options]`
+type GcArguments struct {
+	Verbose   bool
+	DryRun    bool
+	WorkDir   string
+	OlderThan time.Duration
+	LogOptions
+}
+
+func NewGcArguments() *GcArguments {
+	args := new(GcArguments)
+	return args
+}
+
+func (a *GcArguments) Parse(arguments []string) error {
+	f := flag.NewFlagSet("seneca gc", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+
+	f.BoolVar(&a.Verbose, "vv", false, "")
+	f.BoolVar(&a.DryRun, "dry-run", false, "")
+	f.StringVar(&a.WorkDir, "workdir", "", "")
+	f.DurationVar(&a.OlderThan, "older-than", 24*time.Hour, "")
+	a.LogOptions.flags(f)
+
+	if err := f.Parse(arguments); err != nil {
+		return err
+	}
+	if f.NArg() != 0 {
+		return fmt.Errorf("gc takes no arguments, got %q", f.Args())
+	}
+	return nil
+}
+
+func (a *GcArguments) Validate() error {
+	if a.OlderThan < 0 {
+		return fmt.Errorf("-older-than %s must not be negative", a.OlderThan)
+	}
+	return a.LogOptions.validate()
+}
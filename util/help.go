@@ -15,6 +15,10 @@ seneca animated gif generator
      \/__/        \/__/        \/__/        \/__/        \/__/        \/__/    
 Usage:
   seneca -video-infile <path>
+  seneca scenes [-threshold=0.3] <path>
+  seneca serve [-listen=:8090] [-queue=16] [-workers=1]
+  seneca watch [-config=<preset.json>] [-once] <dir>
+  seneca gc [-older-than=24h] [-dry-run]
   seneca -h
   seneca -version
 
@@ -22,28 +26,136 @@ Options:
   -h                    Show this screen.
   -version              Show version.
   -dry-run              Show what would be done without real invocations.
-  -vv                   More verbose output
+  -vv                   More verbose output, including the stderr of
+                        an ffmpeg that failed. Its full log is kept as
+                        ffmpeg.log in the workspace of a failed run.
+  -json                 Print only a JSON summary of the run on stdout;
+                        everything else goes to stderr.
+  -ffmpeg=<path>        ffmpeg executable (Default: $SENECA_FFMPEG or $PATH)
+  -ffprobe=<path>       ffprobe executable (Default: $SENECA_FFPROBE or $PATH)
   -video-infile=<path>  Path (relative/full) to your mp4/flv/mov etc.. video 
+                        video, an http(s) url or - for stdin.
   -from=00:00:00        Starting frame offset in hh:mm:ss (Default: 00:00:00)
+                        or now, to follow a growing file from its end.
   -length=<duration>    Duration to capture (Default: 3s) 
                         E.g. 2m35s, 1h2m15s
+  -auto-clip            Pick the most active -length window instead of -from
+  -from-frame=<n>       Start at frame n (counting from 0) instead of -from
+  -frames=<count>       Capture this many frames instead of -length
+  -to-frame=<n>         Capture up to & including frame n
+  -preview              First make a small 5 fps <video>-preview.gif of
+                        the window, then ask whether to go on with the
+                        full render. Stops there unless on a terminal.
+  -preview-inline       Also show the preview in terminals that support
+                        iTerm2's inline images.
+  -workdir=<path>       Each run works in its own <path>/seneca/<dir>.
+                        (Default: $TMPDIR)
+  -max-input=<MB>       Largest video read from stdin or a url.
+                        (Default: 1024)
+
+Scene Options:
+  -threshold=<value>    Minimum scene score of a cut. (Default: 0.3)
+                        Range (0, 1)
 
 Codec Options:
   -scale width:height   Scale dimensions of input video (Optional)
                         constraint: width & height must be even integers.
                         e.g. 300:_  height calculated to maintain aspect ratio.
                              _:250  width calculated to maintain aspect ratio.
+                        The display aspect (DAR) is kept with square
+                        pixels, so anamorphic videos are not squashed.
 
   -fps=<value>          frames per second. (Default: 25) 
                         Range [1, 30]
 
+  -parallel=<count>     ffmpegs extracting frames at the same time, each
+                        a segment of at least 5s. (Default: CPU count)
+
+Contact Sheet Options:
+  -sheet=<cols>x<rows>  Tile frames sampled evenly across -from/-length
+                        (or the whole video) into one image. e.g. 4x3
+  -sheet-labels         Stamp each tile with its timestamp
+  -sheet-format=png     png or jpg (Default: png)
+
+Server Options:
+  -listen=:8090         Address of the REST API. (Default: :8090)
+  -queue=<count>        Jobs waiting beyond this are refused. (Default: 16)
+  -workers=<count>      Jobs running at the same time. (Default: 1)
+  -workdir=<path>       Parent of per-job directories.
+                        (Default: $TMPDIR/seneca/jobs)
+  -max-upload=<MB>      Largest accepted upload or download of a
+                        "video" url. (Default: 512)
+
+Watch Options:
+  -config=<path>        JSON preset with "options" (as on the command line),
+                        "extensions", "poll" & "settle" durations.
+  -output=<path>        Where GIFs go. (Default: sibling <dir>-gifs)
+  -errors=<path>        Failed videos & their ffmpeg logs.
+                        (Default: sibling <dir>-errors)
+  -once                 Exit when no video is left to convert.
+
+Garbage Collection Options:
+  -older-than=24h       Remove workspaces of runs that died (e.g. killed)
+                        once they are older than this. Runs also sweep
+                        the workspaces older than 24h when they start.
+
 Progress Reporting Options:
   -port=8080            TCP port for progress bar. (Default: 8080)
 
+Logging Options:
+  -log-level=<level>    debug, info, warn or error written to stderr.
+                        (Default: info, or debug with -vv)
+  -log-format=text      text or json (one object per line)
+
 Animated GIF Options:
   -speed=<value>        Slow down or speed up animation. (Default: placebo)
                         e.g. veryfast, faster, placebo, slower, veryslow
 
+  -direct               Make the GIF straight from the frames instead
+                        of through an x264 encoded temp.mp4.
+  -stream               Pipe the frames into the next stage instead of
+                        writing them to disk as PNGs. Not with -dedup.
+
+  -dedup                Collapse runs of identical frames into one
+                        longer frame. Timing is preserved.
+  -dedup-tolerance=<%>  Frames differing by at most this percentage
+                        count as identical. (Default: 0)
+                        Range [0, 100)
+
+  -smooth-loop=<dur>    Crossfade this much of the end of the clip into
+                        its start so the GIF loops seamlessly. The GIF
+                        keeps its -length. e.g. 500ms
+  -auto-loop=<dur>      End the clip on the frame, within this much of
+                        -length, that best loops back to its start.
+                        Adjusts -length. e.g. 1s
+  -auto-loop-start      With -auto-loop, also move the start as far
+                        into the clip.
+
+  -rotate=<degrees>     Turn the frames clockwise by 90, 180 or 270,
+                        after any rotation the video is tagged with.
+  -flip=<h|v>           Mirror the frames horizontally or vertically.
+  -no-autorotate        Ignore the rotation the video is tagged with,
+                        e.g. by phones.
+
+  -watermark=<png>      Overlay this image on every frame, after -scale.
+                        Not with -sheet.
+  -watermark-position=<corner>
+                        top-left, top-right, bottom-left or bottom-right.
+                        (Default: bottom-right)
+  -watermark-margin=10  Distance in pixels from the edges.
+  -watermark-scale=0.15 Width relative to the GIF's. Range (0, 1]
+  -watermark-opacity=1  Range (0, 1]
+
+  -colors=<count>       Largest palette generated for the GIF.
+                        (Default: 256) Range [4, 256]
+  -dither=<algorithm>   none, bayer[:scale], floyd_steinberg or sierra.
+                        Bayer's scale is in [0, 5]. (Default: sierra)
+  -palette-stats=full   Build the palette from whole frames (full) or
+                        only what changes between them (diff).
+  -palette-per-frame    A new palette for every frame.
+                        Without any of these the gif encoder's fixed
+                        palette is used.
+
   -repeat=<count>   **  Number of times to loop. (Default: loop forever)
   -delay=<seconds>  **  Seconds to pause before repeating animation
   -optimize         **  Attempts to reduce size of generated GIF
@@ -53,7 +165,9 @@ Exit status:
   0  if OK,
   1  if invalid cli arguments (e.g. unable to read supplied video file),
 126  if execution of ffmpeg failed,
-127  if ffmpeg & ffprobe are not found on $PATH.
+127  if ffmpeg & ffprobe are not found (-ffmpeg, -ffprobe, $PATH).
+130  if interrupted with Ctrl-C (SIGINT), 143 with SIGTERM. ffmpeg is
+     stopped & the work directory removed.
 
 
 DEVELOPMENT STATUS:
@@ -0,0 +1,218 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l >= DEBUG && l <= ERROR {
+		return levelNames[l]
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(i), nil
+		}
+	}
+	return INFO, fmt.Errorf("-log-level %q is not debug, info, warn or error",
+		name)
+}
+
+const (
+	TEXT = "text"
+	JSON = "json"
+)
+
+// Writes one line per record, either
+//
+//	2014-06-01T10:00:00Z INFO job finished id=1f2e state=done
+//
+// or with the JSON format
+//
+//	{"time":"2014-06-01T10:00:00Z","level":"info","msg":"job finished",..}
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+	now    func() time.Time
+}
+
+func NewLogger(out io.Writer, level Level, format string) *Logger {
+	return &Logger{out: out, level: level, format: format, now: time.Now}
+}
+
+// Shared by every package, on stderr so that it never mixes
+// with the progress ticks & results on stdout.
+var Log = NewLogger(os.Stderr, INFO, TEXT)
+
+func (l *Logger) SetOutput(out io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = out
+}
+
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+func (l *Logger) SetFormat(format string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.format = format
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= l.level
+}
+
+// keyvals are alternating keys & values e.g. "file", f, "error", err
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(DEBUG, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(INFO, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(WARN, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(ERROR, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "(MISSING)")
+	}
+
+	var buf bytes.Buffer
+	stamp := l.now().UTC().Format(time.RFC3339)
+	if l.format == JSON {
+		fmt.Fprintf(&buf, `{"time":%q,"level":%q,"msg":%s`,
+			stamp, level, jsonValue(msg))
+		for i := 0; i < len(keyvals); i += 2 {
+			fmt.Fprintf(&buf, ",%s:%s", jsonValue(fmt.Sprint(keyvals[i])),
+				jsonValue(keyvals[i+1]))
+		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buf, "%s %-5s %s", stamp,
+			strings.ToUpper(level.String()), msg)
+		for i := 0; i < len(keyvals); i += 2 {
+			fmt.Fprintf(&buf, " %s=%s", keyvals[i], textValue(keyvals[i+1]))
+		}
+		buf.WriteString("\n")
+	}
+	l.out.Write(buf.Bytes())
+}
+
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func jsonValue(v interface{}) []byte {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case fmt.Stringer:
+		v = t.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return data
+}
+
+// -log-level & -log-format, shared by seneca and its subcommands
+type LogOptions struct {
+	LogLevel  string
+	LogFormat string
+}
+
+func (o *LogOptions) flags(f *flag.FlagSet) {
+	f.StringVar(&o.LogLevel, "log-level", "", "")
+	f.StringVar(&o.LogFormat, "log-format", TEXT, "")
+}
+
+func (o *LogOptions) validate() error {
+	if !IsEmpty(o.LogLevel) {
+		if _, err := ParseLevel(o.LogLevel); err != nil {
+			return err
+		}
+	}
+	if o.LogFormat != TEXT && o.LogFormat != JSON {
+		return fmt.Errorf("-log-format %q is not text or json", o.LogFormat)
+	}
+	return nil
+}
+
+// ConfigureLog applies the options to Log. Without -log-level,
+// -vv means debug.
+func (o *LogOptions) ConfigureLog(verbose bool) {
+	level := INFO
+	if verbose {
+		level = DEBUG
+	}
+	if !IsEmpty(o.LogLevel) {
+		level, _ = ParseLevel(o.LogLevel)
+	}
+	Log.SetLevel(level)
+	Log.SetFormat(o.LogFormat)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fixedLogger(buf *bytes.Buffer, level Level, format string) *Logger {
+	l := NewLogger(buf, level, format)
+	l.now = func() time.Time {
+		return time.Date(2014, 6, 1, 10, 0, 0, 0, time.UTC)
+	}
+	return l
+}
+
+func TestParseLevel(t *testing.T) {
+	for i, name := range []string{"debug", "INFO", "Warn", "error"} {
+		level, err := ParseLevel(name)
+		assert.NoError(t, err)
+		assert.Equal(t, Level(i), level)
+	}
+	_, err := ParseLevel("trace")
+	assert.Error(t, err)
+	assert.Equal(t, "warn", WARN.String())
+}
+
+func TestLoggerText(t *testing.T) {
+	var buf bytes.Buffer
+	l := fixedLogger(&buf, INFO, TEXT)
+
+	l.Debug("hidden")
+	l.Info("job finished", "id", "1f2e", "state", "done")
+	l.Error("failed", "file", "my video.mp4", "error", errors.New("exit status 1"))
+	l.Warn("odd", "key")
+
+	assert.Equal(t, "2014-06-01T10:00:00Z INFO  job finished id=1f2e state=done\n"+
+		"2014-06-01T10:00:00Z ERROR failed file=\"my video.mp4\" error=\"exit status 1\"\n"+
+		"2014-06-01T10:00:00Z WARN  odd key=(MISSING)\n", buf.String())
+}
+
+func TestLoggerJson(t *testing.T) {
+	var buf bytes.Buffer
+	l := fixedLogger(&buf, DEBUG, JSON)
+
+	l.Debug("probed", "duration", 3*time.Second, "fps", 25.0,
+		"error", errors.New("boom"))
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, map[string]interface{}{
+		"time":     "2014-06-01T10:00:00Z",
+		"level":    "debug",
+		"msg":      "probed",
+		"duration": "3s",
+		"fps":      25.0,
+		"error":    "boom",
+	}, record)
+}
+
+func TestLogOptions(t *testing.T) {
+	args := NewArguments()
+	assert.NoError(t, args.Parse([]string{"-log-level", "warn", "-log-format", "json"}))
+	assert.NoError(t, args.LogOptions.validate())
+	args.ConfigureLog(true)
+	assert.False(t, Log.Enabled(INFO))
+	assert.True(t, Log.Enabled(WARN))
+
+	args = NewArguments()
+	assert.NoError(t, args.Parse([]string{"-vv"}))
+	args.ConfigureLog(args.Verbose)
+	assert.True(t, Log.Enabled(DEBUG))
+	Log.SetLevel(INFO)
+	Log.SetFormat(TEXT)
+
+	args = NewArguments()
+	assert.NoError(t, args.Parse([]string{"-log-format", "xml"}))
+	assert.Error(t, args.LogOptions.validate())
+
+	_, err := OptionArgs(map[string]interface{}{"log-level": "debug"})
+	assert.Error(t, err)
+}
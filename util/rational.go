@@ -0,0 +1,83 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+)
+
+var InvalidRate = errors.New("frame rate is invalid")
+
+// A frame rate as a fraction, e.g. 30000/1001 for NTSC's 29.97,
+// or an aspect ratio e.g. 4/3
+type Rational struct {
+	Num int64
+	Den int64
+}
+
+func (r Rational) Valid() bool {
+	return r.Num > 0 && r.Den > 0
+}
+
+func (r Rational) Float() float64 {
+	if !r.Valid() {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+func (r Rational) String() string {
+	if r.Den == 1 {
+		return fmt.Sprintf("%d", r.Num)
+	}
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// Parses a rate as ffprobe prints it e.g. 25, 29.97 or 30000/1001.
+// ffprobe rounds the NTSC rates (N*1000/1001) to 2 decimals, so
+// those are recognized & made exact again.
+func ParseRate(raw string) (Rational, error) {
+	r, ok := new(big.Rat).SetString(raw)
+	if !ok || r.Sign() <= 0 || !r.Num().IsInt64() || !r.Denom().IsInt64() {
+		return Rational{}, InvalidRate
+	}
+	if r.IsInt() {
+		return Rational{r.Num().Int64(), 1}, nil
+	}
+
+	f, _ := r.Float64()
+	if n := math.Round(f * 1.001); n >= 1 && math.Abs(n/1.001-f) < 0.01 {
+		return Rational{int64(n) * 1000, 1001}, nil
+	}
+	return Rational{r.Num().Int64(), r.Denom().Int64()}, nil
+}
+
+// When frame n starts, rounded down to the nanosecond
+func (r Rational) FrameOffset(n int64) time.Duration {
+	if !r.Valid() || n <= 0 {
+		return 0
+	}
+	ns := new(big.Int).Mul(big.NewInt(n), big.NewInt(r.Den))
+	ns.Mul(ns, big.NewInt(int64(time.Second)))
+	ns.Quo(ns, big.NewInt(r.Num))
+	return time.Duration(ns.Int64())
+}
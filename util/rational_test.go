@@ -0,0 +1,56 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package util_test
+
+import (
+	"github.com/javouhey/seneca/util"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for raw, want := range map[string]util.Rational{
+		"25":         {25, 1},
+		"12.5":       {25, 2},
+		"29.97":      {30000, 1001},
+		"23.98":      {24000, 1001},
+		"23.97":      {24000, 1001},
+		"59.94":      {60000, 1001},
+		"30000/1001": {30000, 1001},
+	} {
+		r, err := util.ParseRate(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, want, r, raw)
+	}
+	for _, bad := range []string{"", "0", "-25", "fps"} {
+		_, err := util.ParseRate(bad)
+		assert.Equal(t, util.InvalidRate, err, bad)
+	}
+}
+
+func TestFrameOffset(t *testing.T) {
+	ntsc := util.Rational{30000, 1001}
+	assert.Equal(t, 1001*time.Millisecond, ntsc.FrameOffset(30))
+	assert.Equal(t, 33366666*time.Nanosecond, ntsc.FrameOffset(1))
+	// one hour of frames without drifting
+	assert.Equal(t, time.Hour+3600*time.Millisecond,
+		ntsc.FrameOffset(108000))
+	assert.Equal(t, 4*time.Second, util.Rational{25, 1}.FrameOffset(100))
+	assert.Equal(t, time.Duration(0), util.Rational{}.FrameOffset(100))
+}
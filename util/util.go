@@ -20,7 +20,8 @@ package util
 import (
 	"errors"
 	"fmt"
-	"log"
+	"image/png"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/user"
@@ -34,6 +35,7 @@ import (
 var (
 	MissingProgramError = errors.New("program name is invalid")
 	InvalidPath         = errors.New("bad path supplied")
+	InvalidPng          = errors.New("not a PNG image")
 
 	// Duration: 00:08:20
 	regexStartTime = regexp.MustCompile(`^(?P<hour>\d{2}):(?P<minute>\d{2}):(?P<second>\d{2})$`)
@@ -43,7 +45,7 @@ var (
 // ss - string representation of an instant to start the capture
 func ParseStartTime(ss string, total time.Duration) (time.Duration, error) {
 	if IsEmpty(ss) {
-		log.Fatalf("%s not in format 00:00:00", ss)
+		return 0, fmt.Errorf("%q not in format 00:00:00", ss)
 	}
 	//TODO
 	time.ParseDuration("00h01m04s")
@@ -96,6 +98,47 @@ func SanitizeFile(path string) (string, error) {
 	return candidateFile, nil
 }
 
+// SanitizeFile for a PNG e.g. -watermark, whose header must parse
+func SanitizePng(path string) (string, error) {
+	file, err := SanitizeFile(path)
+	if err != nil {
+		return file, err
+	}
+	fh, err := os.Open(file)
+	if err != nil {
+		return file, err
+	}
+	defer fh.Close()
+	if _, err := png.DecodeConfig(fh); err != nil {
+		return file, InvalidPng
+	}
+	return file, nil
+}
+
+// -video-infile - reads the video from stdin
+func IsStdin(path string) bool {
+	return path == "-"
+}
+
+// Whether path is an http or https URL
+func IsUrl(path string) bool {
+	u, err := url.Parse(path)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return (scheme == "http" || scheme == "https") && !IsEmpty(u.Host)
+}
+
+// Local files are sanitized, stdin & URLs are fetched later
+func ValidateInput(path string) error {
+	if IsStdin(path) || IsUrl(path) {
+		return nil
+	}
+	_, err := SanitizeFile(path)
+	return err
+}
+
 func IsEmpty(arg string) bool {
 	return strings.TrimSpace(arg) == ""
 }
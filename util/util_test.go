@@ -29,3 +29,18 @@ func TestEmptyCheck(t *testing.T) {
 	assert.False(t, util.IsEmpty(" a "), "after trimming is length 1 is not empty")
 	assert.False(t, util.IsEmpty("a"), "string of length 1 is not empty")
 }
+
+func TestInputKinds(t *testing.T) {
+	assert.True(t, util.IsStdin("-"))
+	assert.False(t, util.IsStdin("--"))
+
+	assert.True(t, util.IsUrl("http://ci.example.com/build/42/demo.mp4"))
+	assert.True(t, util.IsUrl("HTTPS://127.0.0.1:8000/a.mov"))
+	assert.False(t, util.IsUrl("ftp://ci.example.com/demo.mp4"))
+	assert.False(t, util.IsUrl("http://"))
+	assert.False(t, util.IsUrl("/videos/demo.mp4"))
+
+	assert.NoError(t, util.ValidateInput("-"))
+	assert.NoError(t, util.ValidateInput("https://example.com/demo.mp4"))
+	assert.Error(t, util.ValidateInput("/no/such/demo.mp4"))
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/javouhey/seneca/progress"
+	"github.com/javouhey/seneca/util"
+	"github.com/javouhey/seneca/watch"
+)
+
+// `seneca watch <dir>` converts videos dropped into dir
+func runWatch(arguments []string) int {
+	args := util.NewWatchArguments()
+	if err := args.Parse(arguments); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+
+	if err := args.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+	args.ConfigureLog(args.Verbose)
+
+	preset, err := watch.LoadPreset(args.Config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+
+	w, err := watch.New(watch.Config{
+		Dir:    args.Dir,
+		Output: args.Output,
+		Errors: args.Errors,
+		Port:   args.Port,
+		Preset: *preset,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, util.ShortHelp)
+		return 1
+	}
+
+	if code := configurePrograms(args.Ffmpeg, args.Ffprobe); code != 0 {
+		return code
+	}
+
+	listener, err := NewTCPListener(args.Port)
+	if err != nil {
+		return 1
+	}
+	defer listener.Close()
+	pings := make(chan progress.Status)
+	go progress.Progress(listener, pings, args.Port)
+	go func() {
+		for range pings {
+		}
+	}()
+
+	stop, caught, release := closeOnSignal()
+	defer release()
+	util.Log.Info("watching", "dir", w.Dir, "gifs", w.Output,
+		"errors", w.Errors)
+	if err := w.Run(stop, args.Once); err != nil {
+		util.Log.Error("watch stopped", "dir", w.Dir, "error", err)
+		return 1
+	}
+	if sig := caught(); sig != nil {
+		util.Log.Info("watch stopped", "dir", w.Dir, "signal", sig)
+		return signalStatus(sig)
+	}
+	return 0
+}
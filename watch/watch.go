@@ -0,0 +1,450 @@
+/*
+Copyright 2014 Gavin Bong.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+either express or implied. See the License for the specific
+language governing permissions and limitations under the
+License.
+*/
+
+// Polls a directory & converts videos once they stop growing
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	stdio "io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/util"
+)
+
+const STATEFILE = ".seneca-watch.json"
+
+var DefaultExtensions = []string{
+	".mp4", ".mov", ".mkv", ".webm", ".flv", ".avi", ".m4v",
+}
+
+// Contents of the -config file
+//
+//	{
+//	  "extensions": [".mp4", ".mov"],
+//	  "poll": "2s",
+//	  "settle": "5s",
+//	  "options": {"fps": 10, "scale": "480:_", "length": "5s"}
+//	}
+type Preset struct {
+	Extensions []string               `json:"extensions"`
+	Poll       string                 `json:"poll"`
+	Settle     string                 `json:"settle"`
+	Options    map[string]interface{} `json:"options"`
+}
+
+func LoadPreset(file string) (*Preset, error) {
+	preset := &Preset{Poll: "2s", Settle: "5s"}
+	if util.IsEmpty(file) {
+		return preset, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, preset); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return preset, nil
+}
+
+type Config struct {
+	Dir    string
+	Output string // sibling <dir>-gifs when empty
+	Errors string // sibling <dir>-errors when empty
+	Port   int
+	Preset
+}
+
+// Outcome of a video, keyed by name in STATEFILE
+type Record struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	Failed  bool      `json:"failed"`
+	Output  string    `json:"output,omitempty"`
+}
+
+func (r Record) matches(fi os.FileInfo) bool {
+	return r.Size == fi.Size() && r.ModTime.Equal(fi.ModTime())
+}
+
+// A file that has been seen but has not settled yet
+type candidate struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+type Watcher struct {
+	Config
+	poll, settle time.Duration
+	extensions   map[string]struct{}
+	args         []string
+	pending      map[string]*candidate
+	processed    map[string]Record
+	stop         <-chan struct{} // of Run, stops a conversion
+}
+
+func sibling(dir, suffix string) string {
+	dir = filepath.Clean(dir)
+	return filepath.Join(filepath.Dir(dir), filepath.Base(dir)+suffix)
+}
+
+// New validates the preset, creates the output & error
+// directories and loads the record of processed files.
+func New(c Config) (*Watcher, error) {
+	fi, err := os.Stat(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", c.Dir)
+	}
+	if util.IsEmpty(c.Output) {
+		c.Output = sibling(c.Dir, "-gifs")
+	}
+	if util.IsEmpty(c.Errors) {
+		c.Errors = sibling(c.Dir, "-errors")
+	}
+
+	w := &Watcher{
+		Config:     c,
+		extensions: make(map[string]struct{}),
+		pending:    make(map[string]*candidate),
+		processed:  make(map[string]Record),
+	}
+	if w.poll, err = time.ParseDuration(c.Poll); err != nil {
+		return nil, fmt.Errorf("poll %q: %v", c.Poll, err)
+	}
+	if w.settle, err = time.ParseDuration(c.Settle); err != nil {
+		return nil, fmt.Errorf("settle %q: %v", c.Settle, err)
+	}
+	exts := c.Extensions
+	if len(exts) == 0 {
+		exts = DefaultExtensions
+	}
+	for _, ext := range exts {
+		w.extensions[strings.ToLower(ext)] = struct{}{}
+	}
+
+	// fail early on a bad preset rather than once per video
+	if w.args, err = util.OptionArgs(c.Options); err != nil {
+		return nil, err
+	}
+	if err = util.NewArguments().Parse(w.args); err != nil {
+		return nil, err
+	}
+
+	for _, dir := range []string{c.Output, c.Errors} {
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.load(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Watcher) load() error {
+	data, err := ioutil.ReadFile(filepath.Join(w.Output, STATEFILE))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &w.processed)
+}
+
+// Written to a temporary file first so a crash never leaves
+// a truncated record behind.
+func (w *Watcher) save() error {
+	data, err := json.MarshalIndent(w.processed, "", "  ")
+	if err != nil {
+		return err
+	}
+	state := filepath.Join(w.Output, STATEFILE)
+	if err = ioutil.WriteFile(state+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(state+".tmp", state)
+}
+
+func (w *Watcher) eligible(fi os.FileInfo) bool {
+	name := fi.Name()
+	if fi.IsDir() || strings.HasPrefix(name, ".") {
+		return false
+	}
+	_, ok := w.extensions[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// Scan returns the videos whose size & modification time have
+// not changed for the settle period.
+func (w *Watcher) Scan(now time.Time) ([]string, error) {
+	infos, err := ioutil.ReadDir(w.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make([]string, 0)
+	present := make(map[string]struct{})
+	for _, fi := range infos {
+		name := fi.Name()
+		if !w.eligible(fi) {
+			continue
+		}
+		if r, ok := w.processed[name]; ok && r.matches(fi) {
+			continue
+		}
+		present[name] = struct{}{}
+
+		c, ok := w.pending[name]
+		if !ok || c.size != fi.Size() || !c.modTime.Equal(fi.ModTime()) {
+			w.pending[name] = &candidate{fi.Size(), fi.ModTime(), now}
+			continue
+		}
+		if now.Sub(c.since) >= w.settle {
+			ready = append(ready, name)
+		}
+	}
+
+	// forget files that disappeared while growing
+	for name := range w.pending {
+		if _, ok := present[name]; !ok {
+			delete(w.pending, name)
+		}
+	}
+	sort.Strings(ready)
+	return ready, nil
+}
+
+// Process converts one settled video and records the outcome.
+// A failed video is moved to the error directory along with
+// the ffmpeg log.
+func (w *Watcher) Process(name string) error {
+	video := filepath.Join(w.Dir, name)
+	fi, err := os.Stat(video)
+	if err != nil {
+		return err
+	}
+	delete(w.pending, name)
+
+	record := Record{Size: fi.Size(), ModTime: fi.ModTime()}
+	output, log, err := w.convert(video)
+	if err == io.ErrCancelled {
+		// not a failure, the video settles again next time
+		return err
+	}
+	if err == nil {
+		record.Output = output
+		util.Log.Info("converted", "video", name, "gif", output)
+	} else {
+		record.Failed = true
+		if merr := w.reject(video, log); merr != nil {
+			// not recorded so the next scan retries it
+			util.Log.Error("unable to move video", "video", name,
+				"errors", w.Errors, "error", merr)
+			return err
+		}
+	}
+
+	w.processed[name] = record
+	if serr := w.save(); serr != nil {
+		return serr
+	}
+	return err
+}
+
+// Runs the pipeline in a scratch directory. Returns the path
+// of the result in the output directory and the ffmpeg log.
+func (w *Watcher) convert(video string) (string, string, error) {
+	scratch, err := ioutil.TempDir("", "seneca-watch")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(scratch)
+
+	// kept for reject after the scratch directory is gone
+	log, err := ioutil.TempFile("", "seneca-watch-log")
+	if err != nil {
+		return "", "", err
+	}
+	log.Close()
+
+	args := util.NewArguments()
+	if err = args.Parse(w.args); err != nil {
+		return "", log.Name(), err
+	}
+	args.VideoIn = video
+	args.Port = w.Port
+	if err = args.Validate(); err != nil {
+		return "", log.Name(), err
+	}
+
+	vr, err := io.NewVideoReader(video, false)
+	if err != nil {
+		return "", log.Name(), fmt.Errorf("%q is not a recognizable video file",
+			video)
+	}
+	vr.Root = scratch
+	vr.Log = io.LOGFILE
+
+	p := new(io.Pipeline)
+	p.Run(vr, args)
+	go func() {
+		select {
+		case <-w.stop:
+			p.Stop()
+		case <-p.Tombstone.Dead():
+		}
+	}()
+	err = p.Tombstone.Wait()
+	copyFile(vr.LogFile(), log.Name())
+	if err != nil {
+		return "", log.Name(), err
+	}
+
+	suffix := ".gif"
+	if !util.IsEmpty(vr.Sheet) {
+		suffix = "-sheet" + filepath.Ext(vr.Sheet)
+	}
+	out, err := createOutput(w.Output, video, suffix)
+	if err != nil {
+		return "", log.Name(), err
+	}
+	if err = copyInto(vr.Result(), out); err != nil {
+		os.Remove(out.Name())
+		return "", log.Name(), err
+	}
+	os.Remove(log.Name())
+	return out.Name(), "", nil
+}
+
+// Named after all of the video's name but its extension, with
+// -2, -3 .. added when taken, so that clip.mp4 & clip.mov or
+// a.1.mp4 & a.2.mp4 never overwrite each other's output
+func createOutput(dir, video, suffix string) (*os.File, error) {
+	base := filepath.Base(video)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	name := stem + suffix
+	for i := 2; ; i++ {
+		fh, err := os.OpenFile(filepath.Join(dir, name),
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return fh, err
+		}
+		name = fmt.Sprintf("%s-%d%s", stem, i, suffix)
+	}
+}
+
+func (w *Watcher) reject(video, log string) error {
+	name := filepath.Base(video)
+	if !util.IsEmpty(log) {
+		defer os.Remove(log)
+		if err := copyFile(log, filepath.Join(w.Errors, name+".log")); err != nil {
+			return err
+		}
+	}
+	return moveFile(video, filepath.Join(w.Errors, name))
+}
+
+// Run polls until stop is closed, which also stops the
+// conversion under way. With once it returns after the first
+// scan that finds nothing left to settle.
+func (w *Watcher) Run(stop <-chan struct{}, once bool) error {
+	w.stop = stop
+	ticker := time.NewTicker(w.poll)
+	defer ticker.Stop()
+	for {
+		ready, err := w.Scan(time.Now())
+		if err != nil {
+			return err
+		}
+		for _, name := range ready {
+			err := w.Process(name)
+			if err == io.ErrCancelled {
+				return nil
+			}
+			if err != nil {
+				util.Log.Error("conversion failed", "video", name,
+					"error", err)
+			}
+		}
+		if once && len(w.pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	return copyTo(in, out)
+}
+
+// Copies src into out, which is closed
+func copyInto(src string, out *os.File) error {
+	in, err := os.Open(src)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	defer in.Close()
+	return copyTo(in, out)
+}
+
+func copyTo(in stdio.Reader, out *os.File) error {
+	if _, err := stdio.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Falls back to copying when src & dst are on different devices
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,215 @@
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/javouhey/seneca/io"
+	"github.com/javouhey/seneca/io/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWatcher(t *testing.T) (*Watcher, string) {
+	tmp, err := ioutil.TempDir("", "seneca-watch-test")
+	assert.NoError(t, err)
+	dir := filepath.Join(tmp, "drop")
+	assert.NoError(t, os.Mkdir(dir, os.ModePerm))
+
+	preset, err := LoadPreset("")
+	assert.NoError(t, err)
+	preset.Options = map[string]interface{}{"fps": float64(10)}
+	w, err := New(Config{Dir: dir, Port: 8080, Preset: *preset})
+	assert.NoError(t, err)
+	return w, tmp
+}
+
+func TestLoadPreset(t *testing.T) {
+	tmp, _ := ioutil.TempDir("", "seneca-preset")
+	defer os.RemoveAll(tmp)
+
+	file := filepath.Join(tmp, "preset.json")
+	ioutil.WriteFile(file, []byte(`{"settle": "1s",
+		"extensions": [".MOV"], "options": {"scale": "480:_"}}`), 0644)
+	p, err := LoadPreset(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "1s", p.Settle)
+	assert.Equal(t, "2s", p.Poll)
+	assert.Equal(t, "480:_", p.Options["scale"])
+
+	ioutil.WriteFile(file, []byte(`{"settle": `), 0644)
+	_, err = LoadPreset(file)
+	assert.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	w, tmp := newTestWatcher(t)
+	defer os.RemoveAll(tmp)
+
+	assert.Equal(t, filepath.Join(tmp, "drop-gifs"), w.Output)
+	assert.Equal(t, filepath.Join(tmp, "drop-errors"), w.Errors)
+	for _, dir := range []string{w.Output, w.Errors} {
+		fi, err := os.Stat(dir)
+		assert.NoError(t, err)
+		assert.True(t, fi.IsDir())
+	}
+
+	_, err := New(Config{Dir: w.Dir, Preset: Preset{Poll: "2s", Settle: "5s",
+		Options: map[string]interface{}{"port": float64(1)}}})
+	assert.Error(t, err)
+
+	_, err = New(Config{Dir: w.Dir, Preset: Preset{Poll: "2s", Settle: "5s",
+		Options: map[string]interface{}{"scale": "3:x"}}})
+	assert.Error(t, err)
+}
+
+func TestScanWaitsForGrowthToStop(t *testing.T) {
+	w, tmp := newTestWatcher(t)
+	defer os.RemoveAll(tmp)
+
+	video := filepath.Join(w.Dir, "clip.mp4")
+	ioutil.WriteFile(video, []byte("abc"), 0644)
+	ioutil.WriteFile(filepath.Join(w.Dir, "notes.txt"), []byte("x"), 0644)
+	ioutil.WriteFile(filepath.Join(w.Dir, ".hidden.mp4"), []byte("x"), 0644)
+
+	t0 := time.Now()
+	ready, err := w.Scan(t0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(ready))
+
+	ready, _ = w.Scan(t0.Add(4 * time.Second))
+	assert.Equal(t, 0, len(ready))
+
+	// still growing: the settle period starts over
+	ioutil.WriteFile(video, []byte("abcdef"), 0644)
+	ready, _ = w.Scan(t0.Add(6 * time.Second))
+	assert.Equal(t, 0, len(ready))
+	ready, _ = w.Scan(t0.Add(10 * time.Second))
+	assert.Equal(t, 0, len(ready))
+
+	ready, _ = w.Scan(t0.Add(11 * time.Second))
+	assert.Equal(t, []string{"clip.mp4"}, ready)
+
+	os.Remove(video)
+	w.Scan(t0.Add(12 * time.Second))
+	assert.Equal(t, 0, len(w.pending))
+}
+
+func TestFailedVideoMovesToErrors(t *testing.T) {
+	w, tmp := newTestWatcher(t)
+	defer os.RemoveAll(tmp)
+
+	// ffprobe is not configured in tests so probing fails
+	video := filepath.Join(w.Dir, "broken.mp4")
+	ioutil.WriteFile(video, []byte("abc"), 0644)
+	assert.Error(t, w.Process("broken.mp4"))
+
+	_, err := os.Stat(video)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(w.Errors, "broken.mp4"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(w.Errors, "broken.mp4.log"))
+	assert.NoError(t, err)
+
+	// a restart remembers what was done
+	again, err := New(w.Config)
+	assert.NoError(t, err)
+	assert.True(t, again.processed["broken.mp4"].Failed)
+}
+
+func TestFailedVideoIsRetriedWhenNotMoved(t *testing.T) {
+	w, tmp := newTestWatcher(t)
+	defer os.RemoveAll(tmp)
+
+	// the errors directory is gone & a file took its place
+	assert.NoError(t, os.RemoveAll(w.Errors))
+	assert.NoError(t, ioutil.WriteFile(w.Errors, nil, 0644))
+	video := filepath.Join(w.Dir, "broken.mp4")
+	ioutil.WriteFile(video, []byte("abc"), 0644)
+	assert.Error(t, w.Process("broken.mp4"))
+
+	_, err := os.Stat(video)
+	assert.NoError(t, err)
+	_, done := w.processed["broken.mp4"]
+	assert.False(t, done)
+	t0 := time.Now()
+	w.Scan(t0)
+	ready, _ := w.Scan(t0.Add(time.Minute))
+	assert.Equal(t, []string{"broken.mp4"}, ready)
+}
+
+func TestProcessedFilesAreSkipped(t *testing.T) {
+	w, tmp := newTestWatcher(t)
+	defer os.RemoveAll(tmp)
+
+	video := filepath.Join(w.Dir, "done.mp4")
+	ioutil.WriteFile(video, []byte("abc"), 0644)
+	fi, _ := os.Stat(video)
+	w.processed["done.mp4"] = Record{Size: fi.Size(), ModTime: fi.ModTime()}
+	assert.NoError(t, w.save())
+
+	again, err := New(w.Config)
+	assert.NoError(t, err)
+	t0 := time.Now()
+	again.Scan(t0)
+	ready, _ := again.Scan(t0.Add(time.Minute))
+	assert.Equal(t, 0, len(ready))
+
+	// replaced with a new recording of the same name
+	ioutil.WriteFile(video, []byte("abcdef"), 0644)
+	again.Scan(t0)
+	ready, _ = again.Scan(t0.Add(time.Minute))
+	assert.Equal(t, []string{"done.mp4"}, ready)
+}
+
+func TestOutputNamesDoNotCollide(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "seneca-watch-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	var names []string
+	for _, video := range []string{"/drop/clip.mp4", "/drop/clip.mov",
+		"/drop/a.1.mp4", "/drop/a.2.mp4", "/drop/clip.mp4"} {
+		fh, err := createOutput(tmp, video, ".gif")
+		if assert.NoError(t, err) {
+			fh.Close()
+			names = append(names, filepath.Base(fh.Name()))
+		}
+	}
+	assert.Equal(t, []string{"clip.gif", "clip-2.gif", "a.1.gif", "a.2.gif",
+		"clip-3.gif"}, names)
+
+	fh, err := createOutput(tmp, "/drop/plane.mp4", "-sheet.jpg")
+	assert.NoError(t, err)
+	fh.Close()
+	assert.Equal(t, filepath.Join(tmp, "plane-sheet.jpg"), fh.Name())
+}
+
+func TestStopCancelsConversion(t *testing.T) {
+	r := fake.NewRunner()
+	r.On("ffprobe").Stderr = fake.Probe(60*time.Second, 640, 480, 25)
+	r.On("image2 -vsync cfr").Delay = time.Minute
+	io.SetRunner(r)
+	defer io.SetRunner(nil)
+	assert.NoError(t, io.Configure(io.Programs{}))
+
+	w, tmp := newTestWatcher(t)
+	defer os.RemoveAll(tmp)
+	video := filepath.Join(w.Dir, "clip.mp4")
+	ioutil.WriteFile(video, []byte("abc"), 0644)
+
+	stop := make(chan struct{})
+	w.stop = stop
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	start := time.Now()
+	assert.Equal(t, io.ErrCancelled, w.Process("clip.mp4"))
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// left to be converted by the next run
+	_, err := os.Stat(video)
+	assert.NoError(t, err)
+	_, done := w.processed["clip.mp4"]
+	assert.False(t, done)
+}